@@ -0,0 +1,27 @@
+package mrim
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrWakeupDisabled is returned by SendAlarm if FeatureWakeup isn't set in Options.Features.
+var ErrWakeupDisabled = errors.New("mrim: wakeup feature is disabled")
+
+// AlarmEvent is a wakeup ("buzz") from the contact.
+// It's received as a message with MessageFlagAlarm.
+type AlarmEvent struct {
+	From string
+}
+
+// SendAlarm sends wakeup message to the contact.
+// The feature must be advertised at login with FeatureWakeup in Options.Features.
+func (c *Client) SendAlarm(ctx context.Context, to string) error {
+	if c.features&FeatureWakeup == 0 {
+		return ErrWakeupDisabled
+	}
+	opt := &MessageOptions{
+		Flags: MessageFlagAlarm | MessageFlagNorecv,
+	}
+	return c.SendMessage(ctx, to, "", opt)
+}
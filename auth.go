@@ -0,0 +1,95 @@
+package mrim
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+)
+
+// AuthRequest is a request for authorization, which is received as a message with MessageFlagAuthorize.
+type AuthRequest struct {
+	// Message is the message, which carried the request.
+	Message  Message
+	From     string
+	Nickname string
+	Text     string
+}
+
+// ParseAuthRequest decodes authorization request from the message's payload.
+// The message's text is a base64 encoded list of LPS: the sender's nickname and the request's text.
+func ParseAuthRequest(m Message) (req AuthRequest, err error) {
+	if m.Flags&MessageFlagAuthorize == 0 {
+		return req, errors.New("mrim: not an authorization request")
+	}
+	req.Message = m
+	req.From = m.From
+
+	data, err := base64.StdEncoding.DecodeString(m.Text)
+	if err != nil {
+		return req, fmt.Errorf("mrim: could not decode authorization request: %v", err)
+	}
+	r := NewPacketReader(data)
+	n := r.ReadUL()
+	if n > 0 {
+		req.Nickname = r.ReadLPSW()
+	}
+	if n > 1 {
+		req.Text = r.ReadLPSW()
+	}
+	if err := r.Err(); err != nil {
+		return req, fmt.Errorf("mrim: could not read authorization request: %v", err)
+	}
+	return req, nil
+}
+
+// packAuthRequest packs the nickname and the text into the authorization request's payload.
+func packAuthRequest(nickname, text string) string {
+	pw := PacketWriter{}
+	pw.WriteData(2)
+	pw.WriteData(LPSW(nickname))
+	pw.WriteData(LPSW(text))
+	return base64.StdEncoding.EncodeToString(pw.b.Bytes())
+}
+
+// RequestAuthorization asks the contact to authorize the user.
+// nickname is the user's name, which is shown to the contact alongside the text.
+func (c *Client) RequestAuthorization(ctx context.Context, to, nickname, text string) error {
+	opt := &MessageOptions{
+		Flags: MessageFlagAuthorize | MessageFlagNorecv,
+	}
+	return c.SendMessage(ctx, to, packAuthRequest(nickname, text), opt)
+}
+
+// Authorize sends "MRIM_CS_AUTHORIZE", which grants authorization to the contact with the given email.
+func (c *Client) Authorize(ctx context.Context, email string) error {
+	data, err := Marshal(csAuthorize{email})
+	if err != nil {
+		return err
+	}
+	return c.currentConn().Do(ctx, MsgCSAuthorize, data)
+}
+
+// csAuthorize is the body of "MRIM_CS_AUTHORIZE" and "MRIM_CS_AUTHORIZE_ACK".
+type csAuthorize struct {
+	User string `mrim:"lps"`
+}
+
+// Authorized is a notification, that the contact has granted authorization to the user.
+// It's received with "MRIM_CS_AUTHORIZE_ACK".
+type Authorized struct {
+	Email string
+}
+
+// ParseAuthorized decodes "MRIM_CS_AUTHORIZE_ACK" packet.
+func ParseAuthorized(p Packet) (a Authorized, err error) {
+	if p.Msg != MsgCSAuthorizeAck {
+		return a, PacketError{p, errUnknownPacket}
+	}
+	var ack csAuthorize
+	if err := Unmarshal(p.Data, &ack); err != nil {
+		return a, PacketError{p, err}
+	}
+	a.Email = ack.User
+	return a, nil
+}
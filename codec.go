@@ -0,0 +1,199 @@
+package mrim
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Marshal encodes struct v into packet's body.
+//
+// Fields are encoded in order of declaration, according to their "mrim" tags:
+//
+//	`mrim:"ul"`         - UL, the field must be of an integer type
+//	`mrim:"lps"`        - LPS written as is, the field must be a string or []byte
+//	`mrim:"lps,cp1251"` - LPS in CP1251, the field must be a string
+//	`mrim:"lpsw"`       - LPS in UTF-16LE, the field must be a string
+//	`mrim:"rest"`       - raw bytes up to the end of the body, the field must be a string or []byte
+//
+// Fields without the tag, or with tag "-", are ignored.
+func Marshal(v interface{}) ([]byte, error) {
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	if rv.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("mrim: could not marshal %T: not a struct", v)
+	}
+
+	pw := PacketWriter{}
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		sf := rt.Field(i)
+		tag, ok := parseTag(sf)
+		if !ok {
+			continue
+		}
+		if err := marshalField(&pw, rv.Field(i), tag); err != nil {
+			return nil, FieldError{rt.Name(), sf.Name, err}
+		}
+	}
+	return pw.b.Bytes(), nil
+}
+
+// Unmarshal decodes packet's body into struct pointed by v.
+// See Marshal for the description of the fields' tags.
+func Unmarshal(data []byte, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("mrim: could not unmarshal into %T: not a pointer to struct", v)
+	}
+	rv = rv.Elem()
+
+	r := NewPacketReader(data)
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		sf := rt.Field(i)
+		tag, ok := parseTag(sf)
+		if !ok {
+			continue
+		}
+		fv := rv.Field(i)
+		if !fv.CanSet() {
+			return FieldError{rt.Name(), sf.Name, errors.New("unexported field")}
+		}
+		if err := unmarshalField(r, fv, tag); err != nil {
+			return FieldError{rt.Name(), sf.Name, err}
+		}
+	}
+	return nil
+}
+
+// FieldError is returned by Marshal and Unmarshal when a field couldn't be encoded or decoded.
+type FieldError struct {
+	Struct string
+	Field  string
+	Err    error
+}
+
+func (e FieldError) Error() string {
+	return fmt.Sprintf("mrim: field %s.%s: %v", e.Struct, e.Field, e.Err)
+}
+
+type fieldTag struct {
+	kind   string
+	cp1251 bool
+}
+
+func parseTag(sf reflect.StructField) (tag fieldTag, ok bool) {
+	v, ok := sf.Tag.Lookup("mrim")
+	if !ok || v == "-" {
+		return tag, false
+	}
+	parts := strings.Split(v, ",")
+	tag.kind = parts[0]
+	for _, opt := range parts[1:] {
+		if opt == "cp1251" {
+			tag.cp1251 = true
+		}
+	}
+	return tag, true
+}
+
+var errBadFieldType = errors.New("unsupported field type")
+
+func marshalField(pw *PacketWriter, fv reflect.Value, tag fieldTag) (err error) {
+	switch tag.kind {
+	case "ul":
+		switch fv.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			_, err = pw.WriteData(uint32(fv.Int()))
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			_, err = pw.WriteData(uint32(fv.Uint()))
+		default:
+			err = errBadFieldType
+		}
+
+	case "lps":
+		switch {
+		case fv.Kind() == reflect.String && tag.cp1251:
+			_, err = pw.WriteData(LPSA(fv.String()))
+		case fv.Kind() == reflect.String:
+			_, err = pw.WriteData(fv.String())
+		case isBytes(fv) && !tag.cp1251:
+			_, err = pw.WriteData(fv.Bytes())
+		default:
+			err = errBadFieldType
+		}
+
+	case "lpsw":
+		if fv.Kind() != reflect.String {
+			return errBadFieldType
+		}
+		_, err = pw.WriteData(LPSW(fv.String()))
+
+	case "rest":
+		switch {
+		case fv.Kind() == reflect.String:
+			_, err = pw.Write([]byte(fv.String()))
+		case isBytes(fv):
+			_, err = pw.Write(fv.Bytes())
+		default:
+			err = errBadFieldType
+		}
+
+	default:
+		err = fmt.Errorf("unknown tag %q", tag.kind)
+	}
+	return err
+}
+
+func unmarshalField(r *PacketReader, fv reflect.Value, tag fieldTag) error {
+	switch tag.kind {
+	case "ul":
+		v := r.ReadUL()
+		switch fv.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			fv.SetInt(int64(v))
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			fv.SetUint(uint64(v))
+		default:
+			return errBadFieldType
+		}
+
+	case "lps":
+		switch {
+		case fv.Kind() == reflect.String && tag.cp1251:
+			fv.SetString(r.ReadLPSA())
+		case fv.Kind() == reflect.String:
+			fv.SetString(r.ReadLPS())
+		case isBytes(fv) && !tag.cp1251:
+			fv.SetBytes([]byte(r.ReadLPS()))
+		default:
+			return errBadFieldType
+		}
+
+	case "lpsw":
+		if fv.Kind() != reflect.String {
+			return errBadFieldType
+		}
+		fv.SetString(r.ReadLPSW())
+
+	case "rest":
+		v := r.ReadBytes(r.Remaining())
+		switch {
+		case fv.Kind() == reflect.String:
+			fv.SetString(string(v))
+		case isBytes(fv):
+			fv.SetBytes(append([]byte(nil), v...))
+		default:
+			return errBadFieldType
+		}
+
+	default:
+		return fmt.Errorf("unknown tag %q", tag.kind)
+	}
+	return r.Err()
+}
+
+func isBytes(fv reflect.Value) bool {
+	return fv.Kind() == reflect.Slice && fv.Type().Elem().Kind() == reflect.Uint8
+}
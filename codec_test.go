@@ -0,0 +1,178 @@
+package mrim
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+type codecUL struct {
+	A uint32 `mrim:"ul"`
+	B int    `mrim:"ul"`
+}
+
+type codecLPS struct {
+	S string `mrim:"lps"`
+	B []byte `mrim:"lps"`
+}
+
+type codecCP1251 struct {
+	S string `mrim:"lps,cp1251"`
+}
+
+type codecLPSW struct {
+	S string `mrim:"lpsw"`
+}
+
+type codecRest struct {
+	A    uint32 `mrim:"ul"`
+	Rest []byte `mrim:"rest"`
+}
+
+type codecSkip struct {
+	A       uint32 `mrim:"ul"`
+	Skipped string `mrim:"-"`
+	Untaged string
+}
+
+func TestMarshalUnmarshal(t *testing.T) {
+	tests := []struct {
+		name string
+		v    interface{}
+		data []byte
+	}{
+		{
+			"ul",
+			&codecUL{A: 0x01020304, B: 5},
+			[]byte{4, 3, 2, 1, 5, 0, 0, 0},
+		},
+		{
+			"lps",
+			&codecLPS{S: "ab", B: []byte{0xff}},
+			[]byte{2, 0, 0, 0, 'a', 'b', 1, 0, 0, 0, 0xff},
+		},
+		{
+			"lps,cp1251",
+			&codecCP1251{S: "Привет"},
+			[]byte{6, 0, 0, 0, 0xcf, 0xf0, 0xe8, 0xe2, 0xe5, 0xf2},
+		},
+		{
+			"lpsw",
+			&codecLPSW{S: "Hi"},
+			[]byte{4, 0, 0, 0, 'H', 0, 'i', 0},
+		},
+		{
+			"rest",
+			&codecRest{A: 1, Rest: []byte{1, 2, 3}},
+			[]byte{1, 0, 0, 0, 1, 2, 3},
+		},
+		{
+			"skipped fields",
+			&codecSkip{A: 1},
+			[]byte{1, 0, 0, 0},
+		},
+	}
+	for _, tt := range tests {
+		data, err := Marshal(tt.v)
+		if err != nil {
+			t.Errorf("%s: Marshal: %v", tt.name, err)
+			continue
+		}
+		if !bytes.Equal(data, tt.data) {
+			t.Errorf("%s: Marshal: got %v, want %v", tt.name, data, tt.data)
+		}
+
+		v := reflect.New(reflect.TypeOf(tt.v).Elem()).Interface()
+		if err := Unmarshal(tt.data, v); err != nil {
+			t.Errorf("%s: Unmarshal: %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(v, tt.v) {
+			t.Errorf("%s: Unmarshal: got %+v, want %+v", tt.name, v, tt.v)
+		}
+	}
+}
+
+type S struct {
+	A uint32 `mrim:"ul"`
+	B string `mrim:"lps"`
+}
+
+func TestUnmarshalShortBody(t *testing.T) {
+	var s S
+	err := Unmarshal([]byte{1, 0, 0, 0, 5, 0, 0, 0, 'a'}, &s)
+	want := FieldError{"S", "B", ErrShortPacket}
+	if err != want {
+		t.Fatalf("got %v, want %v", err, want)
+	}
+	if got, want := err.Error(), "mrim: field S.B: mrim: packet data too short"; got != want {
+		t.Fatalf("got message %q, want %q", got, want)
+	}
+}
+
+type codecBadUL struct {
+	F string `mrim:"ul"`
+}
+
+type codecBadLPS struct {
+	F int `mrim:"lps"`
+}
+
+type codecBadCP1251 struct {
+	F []byte `mrim:"lps,cp1251"`
+}
+
+type codecBadLPSW struct {
+	F []byte `mrim:"lpsw"`
+}
+
+type codecBadRest struct {
+	F int `mrim:"rest"`
+}
+
+type codecBadTag struct {
+	F uint32 `mrim:"ulong"`
+}
+
+type codecUnexported struct {
+	f uint32 `mrim:"ul"`
+}
+
+func TestCodecBadFields(t *testing.T) {
+	data := []byte{4, 0, 0, 0, 'a', 'b', 'c', 'd'}
+	tests := []struct {
+		v       interface{}
+		marshal bool
+		msg     string
+	}{
+		{&codecBadUL{}, true, "mrim: field codecBadUL.F: unsupported field type"},
+		{&codecBadLPS{}, true, "mrim: field codecBadLPS.F: unsupported field type"},
+		{&codecBadCP1251{}, true, "mrim: field codecBadCP1251.F: unsupported field type"},
+		{&codecBadLPSW{}, true, "mrim: field codecBadLPSW.F: unsupported field type"},
+		{&codecBadRest{}, true, "mrim: field codecBadRest.F: unsupported field type"},
+		{&codecBadTag{}, true, `mrim: field codecBadTag.F: unknown tag "ulong"`},
+		{&codecUnexported{}, false, "mrim: field codecUnexported.f: unexported field"},
+	}
+	for _, tt := range tests {
+		if tt.marshal {
+			_, err := Marshal(tt.v)
+			if err == nil || err.Error() != tt.msg {
+				t.Errorf("Marshal %T: got %v, want %q", tt.v, err, tt.msg)
+			}
+		}
+		err := Unmarshal(data, tt.v)
+		if _, ok := err.(FieldError); !ok || err.Error() != tt.msg {
+			t.Errorf("Unmarshal %T: got %v, want %q", tt.v, err, tt.msg)
+		}
+	}
+}
+
+func TestCodecNotStruct(t *testing.T) {
+	if _, err := Marshal(42); err == nil {
+		t.Error("Marshal int: want error")
+	}
+	var s S
+	if err := Unmarshal(nil, s); err == nil {
+		t.Error("Unmarshal into struct value: want error")
+	}
+}
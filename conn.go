@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -15,82 +16,35 @@ import (
 const (
 	// The size of socket reader buffer.
 	mraBufSize = 32768
-	// The size of recv buffer.
-	recvBufSize = 1400
 )
 
+// DefaultMaxPacketSize is the default limit of the packet's body size.
+const DefaultMaxPacketSize = 1 << 20
+
 var (
 	errUnknownPacket = errors.New("unknown packet")
-)
-
-type recvBuf struct {
-	c  chan Packet
-	mu sync.Mutex
-
-	backlog []Packet
-	head    int
-	tail    int
-	size    int
-}
-
-func newRecvBuf(size int) *recvBuf {
-	return &recvBuf{
-		c:       make(chan Packet, 1),
-		backlog: make([]Packet, size),
-		size:    size,
-	}
-}
-
-func (t *recvBuf) put(p Packet) {
-	t.mu.Lock()
-	defer t.mu.Unlock()
-	if t.len() == 0 {
-		select {
-		case t.c <- p:
-			return
-		default:
-			debugf("put packet into backlog: %04x", p.Msg)
-		}
-	}
-	if t.len() >= t.size {
-		debugf("drop packet: %04x", p.Msg)
-		return
-	}
-	t.backlog[t.tail] = p
-	if t.tail >= t.size {
-		t.tail = 0
-	} else {
-		t.tail++
-	}
-}
 
-func (t *recvBuf) load() {
-	t.mu.Lock()
-	defer t.mu.Unlock()
-	if t.len() > 0 {
-		select {
-		case t.c <- t.backlog[t.head]:
-			if t.head >= t.size - 1 {
-				t.head = 0
-			} else {
-				t.head++
-			}
-		default:
-		}
-	}
-}
+	// ErrPacketTooLarge is returned when the packet's body exceeds the limit of the reader.
+	ErrPacketTooLarge = errors.New("mrim: packet too large")
+	// ErrTimeout is the error of the connection, which was closed because a read or a write timed out.
+	ErrTimeout = errors.New("mrim: connection timed out")
+	// ErrClosed is returned when the connection or the client was closed.
+	ErrClosed = errors.New("mrim: use of closed connection")
+)
 
-func (t *recvBuf) take() <-chan Packet {
-	return t.c
-}
+// connState is a state of the connection's lifecycle.
+// The state only moves forward: running → closing → closed.
+type connState int
 
-func (t *recvBuf) len() int {
-	if t.tail >= t.head {
-		return t.tail - t.head
-	}
-	return t.size - t.head + t.tail
-}
+const (
+	stateRunning connState = iota
+	// stateClosing rejects new requests, while the connection is being shut down.
+	stateClosing
+	stateClosed
+)
 
+// closeTimeout limits the time spent on saying goodbye to the server on Close.
+const closeTimeout = time.Second
 
 type Conn struct {
 	Reader
@@ -98,23 +52,43 @@ type Conn struct {
 
 	// keeps a reference to the connection so TLS can be created in future.
 	conn io.ReadWriteCloser
-	// associated context will be used for cancellation in future.
+	// associated context, cancellation of which closes the connection.
 	ctx context.Context
 	// TODO(varankinv): last caught error
 	err error
 
-	// received packets buffer.
-	recvBuf *recvBuf
+	// received packets waiting for Recv.
+	recvQueue *recvQueue
+	// done is closed when the connection starts closing.
+	done chan struct{}
+	// closed is closed when the connection is closed.
+	closed chan struct{}
+	// handler, if set, is called by the reader for every packet, which isn't a reply.
+	handler func(Packet)
+
+	// IdlePings is the number of ping intervals without inbound traffic,
+	// after which the connection is considered dead. Zero disables the check.
+	IdlePings int
+	// WriteTimeout limits writes, which context has no deadline. Zero means no limit.
+	WriteTimeout time.Duration
 
 	mu   sync.RWMutex
 	once sync.Once
 	wg   sync.WaitGroup
 
-	stopped bool
+	running bool
+	state   connState
+	// closeErr is the result of closing the underlying connection.
+	closeErr error
 	// TODO(varankinv): seq pool
 	seq uint32
+	// requests waiting for the reply, keyed by sequence.
+	pending map[uint32]*call
+	// wsem serializes writes to the connection. It's a channel,
+	// so waiting for the writer could be interrupted by the context.
+	wsem chan struct{}
 
-	// ping interval retrieved with MRIM_CS_HELLO_ACK.
+	// ping interval retrieved with MRIM_CS_HELLO_ACK and updated with MRIM_CS_CONNECTION_PARAMS.
 	pingInterval time.Duration
 	pingTimer    *time.Timer
 }
@@ -123,18 +97,37 @@ func NewConn(ctx context.Context, conn io.ReadWriteCloser) *Conn {
 	c := &Conn{
 		conn:    conn,
 		ctx:     ctx,
-		recvBuf: newRecvBuf(recvBufSize),
+		done:    make(chan struct{}),
+		closed:  make(chan struct{}),
+		wsem:    make(chan struct{}, 1),
+		pending: make(map[uint32]*call),
 	}
+	c.recvQueue = newRecvQueue(DefaultRecvQueueSize, OverflowDropNewest, c.done)
 	c.Writer = Writer{
 		bw: bufio.NewWriter(c.conn),
 	}
 	c.Reader = Reader{
-		br:  bufio.NewReader(c.conn),
-		buf: make([]byte, mraBufSize),
+		br: bufio.NewReaderSize(c.conn, mraBufSize),
 	}
 	return c
 }
 
+// SetRecvQueue sets the size of the receive queue and the policy applied when it's full.
+// It must be called before Run.
+func (c *Conn) SetRecvQueue(size int, policy OverflowPolicy) {
+	c.recvQueue = newRecvQueue(size, policy, c.done)
+}
+
+// Dropped returns the number of packets dropped by the receive queue per message type.
+func (c *Conn) Dropped() map[uint32]uint64 {
+	return c.recvQueue.droppedCount()
+}
+
+// Done returns a channel, which is closed when the connection starts closing.
+func (c *Conn) Done() <-chan struct{} {
+	return c.done
+}
+
 func (c *Conn) Run() {
 	c.once.Do(func() {
 		c.mu.Lock()
@@ -144,49 +137,135 @@ func (c *Conn) Run() {
 }
 
 func (c *Conn) run() {
+	if c.state != stateRunning {
+		return
+	}
+	c.running = true
+	c.wg.Add(1)
 	go c.readLoop()
 
-	if c.pingInterval > 0 {
-		pingInterval := c.pingInterval
-		if c.pingTimer == nil {
-			c.pingTimer = time.AfterFunc(c.pingInterval, func() {
-				c.ping(pingInterval)
-			})
-		} else {
-			c.pingTimer.Reset(pingInterval)
-		}
+	if c.ctx.Done() != nil {
+		c.wg.Add(1)
+		go c.watchContext()
+	}
+
+	c.schedulePing()
+}
+
+// PingInterval returns the interval between keepalive pings. Zero means pings are disabled.
+func (c *Conn) PingInterval() time.Duration {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.pingInterval
+}
+
+// SetPingInterval changes the interval between keepalive pings.
+// If the connection is running, the next ping is rescheduled to d from now.
+func (c *Conn) SetPingInterval(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.pingInterval = d
+	if c.pingTimer != nil {
+		c.pingTimer.Stop()
+		c.pingTimer = nil
+	}
+	if c.running && c.state == stateRunning {
+		c.schedulePing()
+	}
+}
+
+// schedulePing arms the ping timer. It must be called with c.mu held.
+func (c *Conn) schedulePing() {
+	if c.pingInterval <= 0 {
+		return
+	}
+	if c.pingTimer == nil {
+		c.pingTimer = time.AfterFunc(c.pingInterval, c.ping)
+	} else {
+		c.pingTimer.Reset(c.pingInterval)
 	}
 }
 
-// FIXME(varankinv): Conn.Close
+// Close closes the connection and waits for its goroutines to exit.
+// If the connection was running, the server is notified with "MRIM_CS_LOGOUT".
+// Pending and future calls of Recv, Send and Call return ErrClosed.
+// It's safe to call Close more than once.
 func (c *Conn) Close() (err error) {
+	err = c.close(true)
+	c.wg.Wait()
+	return err
+}
+
+// close moves the connection to the closing state and shuts it down without waiting
+// for the reader to exit. Concurrent callers wait for the first one to finish.
+func (c *Conn) close(logout bool) error {
 	c.mu.Lock()
-	if !c.stopped {
-		c.stopped = true
+	if c.state != stateRunning {
+		c.mu.Unlock()
+		<-c.closed
+		return c.closeErr
 	}
+	c.state = stateClosing
+	close(c.done)
+	logout = logout && c.running
 
 	if c.pingTimer != nil {
 		c.pingTimer.Stop()
 		c.pingTimer = nil
 	}
+	c.mu.Unlock()
+
+	c.cancelPending()
 
-	// make sure we have flushed the outbound
+	var err error
 	if c.conn != nil {
-		if c.bw.Buffered() > 0 {
-			err = c.Flush()
-			if err != nil {
-				debugf("failed to flush pending data: %v", err)
-			}
-		}
+		c.goodbye(logout)
 		err = c.conn.Close()
 	}
-	c.mu.Unlock()
 
-	c.wg.Wait()
+	c.mu.Lock()
+	c.state = stateClosed
+	c.closeErr = err
+	c.mu.Unlock()
+	close(c.closed)
 
 	return err
 }
 
+// goodbye flushes the outbound and sends "MRIM_CS_LOGOUT", if logout is set.
+// It gives up, if the writer is stuck.
+func (c *Conn) goodbye(logout bool) {
+	t := time.NewTimer(closeTimeout)
+	defer t.Stop()
+	select {
+	case c.wsem <- struct{}{}:
+	case <-t.C:
+		debugf("writer is busy, skip flush")
+		return
+	}
+	defer func() { <-c.wsem }()
+
+	if dc, ok := c.conn.(deadliner); ok {
+		dc.SetWriteDeadline(time.Now().Add(closeTimeout))
+	}
+	if logout {
+		var p Packet
+		p.Seq = atomic.AddUint32(&c.seq, 1)
+		p.Msg = MsgCSLogout
+		p.Data = make([]byte, 4) // reason
+		p.Len = uint32(len(p.Data))
+		if err := c.WritePacket(p); err != nil {
+			debugf("failed to send logout: %v", err)
+		}
+	}
+	if c.bw.Buffered() > 0 {
+		if err := c.Flush(); err != nil {
+			debugf("failed to flush pending data: %v", err)
+		}
+	}
+}
+
 func (c *Conn) Do(ctx context.Context, msg uint32, data []byte) error {
 	// TODO: acquire sequence
 	seq := atomic.AddUint32(&c.seq, 1)
@@ -204,97 +283,321 @@ func (c *Conn) Do(ctx context.Context, msg uint32, data []byte) error {
 	return err
 }
 
+// call is a request waiting for the reply with the same sequence.
+type call struct {
+	reply uint32
+	c     chan Packet
+}
+
+// Call sends the packet msg and waits for the reply packet with the same sequence.
+// The reply is not passed to Recv.
+func (c *Conn) Call(ctx context.Context, msg uint32, data []byte, reply uint32) (p Packet, err error) {
+	seq := atomic.AddUint32(&c.seq, 1)
+
+	cl := &call{
+		reply: reply,
+		c:     make(chan Packet, 1),
+	}
+	c.mu.Lock()
+	if c.state != stateRunning {
+		c.mu.Unlock()
+		return p, ErrClosed
+	}
+	c.pending[seq] = cl
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, seq)
+		c.mu.Unlock()
+	}()
+
+	p.Seq = seq
+	p.Msg = msg
+	p.Len = uint32(len(data))
+	p.Data = data
+
+	err = c.send(ctx, p)
+	if err != nil {
+		return p, err
+	}
+
+	select {
+	case <-ctx.Done():
+		return p, ctx.Err()
+	case p, ok := <-cl.c:
+		if !ok {
+			if err := c.Err(); err != nil {
+				return p, err
+			}
+			return p, ErrClosed
+		}
+		return p, nil
+	}
+}
+
+// deliver passes packet p to the pending call waiting for it.
+// It reports whether p was a reply.
+func (c *Conn) deliver(p Packet) bool {
+	c.mu.Lock()
+	cl, ok := c.pending[p.Seq]
+	if ok && cl.reply == p.Msg {
+		delete(c.pending, p.Seq)
+	} else {
+		ok = false
+	}
+	c.mu.Unlock()
+
+	if ok {
+		cl.c <- p
+	}
+	return ok
+}
+
+// cancelPending releases all calls waiting for the reply.
+func (c *Conn) cancelPending() {
+	c.mu.Lock()
+	for seq, cl := range c.pending {
+		close(cl.c)
+		delete(c.pending, seq)
+	}
+	c.mu.Unlock()
+}
+
 func (c *Conn) Send(ctx context.Context, p Packet) error {
 	return c.send(ctx, p)
 }
 
 func (c *Conn) send(ctx context.Context, p Packet) (err error) {
-	c.mu.RLock()
-	stopped := c.stopped
-	c.mu.RUnlock()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 
-	if stopped {
-		err = io.EOF
-	} else {
+	select {
+	case c.wsem <- struct{}{}:
+	case <-c.done:
+		return c.closedErr()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case <-c.done:
+		err = c.closedErr()
+	default:
+		c.setWriteDeadline(ctx)
 		err = c.WritePacket(p)
+		if err == nil {
+			err = c.Flush()
+		}
 	}
+	<-c.wsem
 
-	if err == nil {
-		return c.Flush()
-	} else {
+	if err != nil {
 		debug(PacketError{p, fmt.Errorf("packet droped: %v", err)})
 	}
-	return
+	select {
+	case <-c.done:
+		// the write was interrupted by Close.
+		return c.closedErr()
+	default:
+	}
+	if isTimeout(err) {
+		// the packet could be partially written, the stream is broken.
+		c.fatal(ErrTimeout)
+		return ErrTimeout
+	}
+	return err
 }
 
-func (c *Conn) ping(d time.Duration) {
-	c.mu.RLock()
-	if c.stopped {
-		c.mu.RUnlock()
+// deadliner is implemented by connections, which support deadlines, e.g. net.Conn.
+type deadliner interface {
+	SetReadDeadline(t time.Time) error
+	SetWriteDeadline(t time.Time) error
+}
+
+// setWriteDeadline sets the deadline of the next write from ctx, or from WriteTimeout.
+// It must be called with c.wsem acquired.
+func (c *Conn) setWriteDeadline(ctx context.Context) {
+	dc, ok := c.conn.(deadliner)
+	if !ok {
 		return
 	}
-	c.mu.RUnlock()
+	var t time.Time
+	if d, ok := ctx.Deadline(); ok {
+		t = d
+	} else if c.WriteTimeout > 0 {
+		t = time.Now().Add(c.WriteTimeout)
+	}
+	dc.SetWriteDeadline(t)
+}
+
+// setReadDeadline sets the deadline of the next read according to IdlePings.
+func (c *Conn) setReadDeadline() {
+	dc, ok := c.conn.(deadliner)
+	if !ok || c.IdlePings <= 0 {
+		return
+	}
+	var t time.Time
+	if d := c.PingInterval(); d > 0 {
+		t = time.Now().Add(time.Duration(c.IdlePings) * d)
+	}
+	dc.SetReadDeadline(t)
+}
+
+// watchContext closes the connection, when its context is done.
+func (c *Conn) watchContext() {
+	defer c.wg.Done()
+
+	select {
+	case <-c.ctx.Done():
+		c.fatal(c.ctx.Err())
+	case <-c.done:
+	}
+}
+
+// aLongTimeAgo is a deadline, which interrupts blocking I/O immediately.
+var aLongTimeAgo = time.Unix(1, 0)
+
+// readContext reads the next packet, interrupting the read when ctx is done.
+// It's used before the reader is started.
+func (c *Conn) readContext(ctx context.Context) (p Packet, err error) {
+	dc, ok := c.conn.(deadliner)
+	if !ok || ctx.Done() == nil {
+		return c.ReadPacket()
+	}
+	if d, ok := ctx.Deadline(); ok {
+		dc.SetReadDeadline(d)
+	}
+
+	done := make(chan struct{})
+	exited := make(chan struct{})
+	go func() {
+		defer close(exited)
+		select {
+		case <-ctx.Done():
+			dc.SetReadDeadline(aLongTimeAgo)
+		case <-done:
+		}
+	}()
+
+	p, err = c.ReadPacket()
+	close(done)
+	<-exited
+	dc.SetReadDeadline(time.Time{})
+
+	if err != nil && ctx.Err() != nil {
+		return p, ctx.Err()
+	}
+	return p, err
+}
+
+// isTimeout reports whether err is caused by the expired deadline.
+func isTimeout(err error) bool {
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
+
+func (c *Conn) ping() {
+	c.mu.Lock()
+	if c.state != stateRunning {
+		c.mu.Unlock()
+		return
+	}
+	// the ping is called by the timer, make Close wait for it.
+	c.wg.Add(1)
+	c.mu.Unlock()
+	defer c.wg.Done()
 
 	// NOTE: there is no such thing as pong.
 	var p Packet
 	p.Header.Msg = MsgCSPing
 	c.Send(c.ctx, p)
 
-	c.pingTimer.Reset(d)
+	c.mu.Lock()
+	if c.state == stateRunning && c.pingTimer != nil {
+		c.pingTimer.Reset(c.pingInterval)
+	}
+	c.mu.Unlock()
 }
 
 // readLoop is run in a goroutine, reading incoming packets.
 func (c *Conn) readLoop() {
-	c.wg.Add(1)
 	defer c.wg.Done()
 
-	var stopped bool
-
-	for !stopped {
+	for {
+		c.setReadDeadline()
 		p, err := c.ReadPacket()
 		if err != nil {
+			if isTimeout(err) {
+				err = ErrTimeout
+			}
 			c.fatal(err)
 			break
 		}
 
-		c.mu.RLock()
-		stopped = c.stopped
-		c.mu.RUnlock()
+		select {
+		case <-c.done:
+			p.Release()
+			return
+		default:
+		}
+
+		if c.deliver(p) {
+			continue
+		}
+		if c.handler != nil {
+			c.handler(p)
+		}
 
-		// put packet into a buffer to consume later
-		c.recvBuf.put(p)
+		// put packet into the queue to consume later
+		if err := c.recvQueue.put(p); err != nil {
+			c.fatal(err)
+			break
+		}
 	}
 }
 
+// Recv returns the next received packet, which isn't a reply to Call.
+// If the connection has failed, packets queued before the failure are returned before the error.
+// After Close, Recv returns ErrClosed.
 func (c *Conn) Recv() (p Packet, err error) {
-	if c.err != nil {
-		return p, c.err
-	}
-	defer func() {
-		c.err = err
-	}()
-
-	c.mu.RLock()
-	stopped := c.stopped
-	c.mu.RUnlock()
+	return c.RecvContext(c.ctx)
+}
 
-	if stopped {
-		return p, io.EOF
+// RecvContext is like Recv, but it also returns when ctx is done.
+func (c *Conn) RecvContext(ctx context.Context) (p Packet, err error) {
+	if c.closedByUser() {
+		return p, ErrClosed
 	}
 
 	select {
 	case <-c.ctx.Done():
+		select {
+		case <-c.done:
+			// the context could be canceled by closing the owner.
+			return p, c.closedErr()
+		default:
+		}
 		return p, c.ctx.Err()
-	case p := <-c.recvBuf.take():
-		c.recvBuf.load()
-
+	case <-ctx.Done():
+		return p, ctx.Err()
+	case <-c.done:
+		if c.closedByUser() {
+			return p, ErrClosed
+		}
+		select {
+		case p := <-c.recvQueue.take():
+			return p, nil
+		default:
+		}
+		return p, c.closedErr()
+	case p := <-c.recvQueue.take():
 		// packets that are not replies
 		switch p.Header.Msg {
 		case MsgCSUserInfo:
 			debugf("< received \"MRIM_CS_USER_INFO\" packet: %04x", p.Msg)
 
 		case MrimCSOfflineMessageAck:
-			// TODO(varankinv): send MRIM_CS_DELETE_OFFLINE_MESSAGE for each offline message.
 			debugf("< received \"MRIM_CS_OFFLINE_MESSAGE_ACK\" packet: %04x", p.Msg)
 
 		case MsgCSContactList2:
@@ -315,52 +618,86 @@ func (c *Conn) Err() error {
 	return err
 }
 
+// closedByUser reports whether the connection was closed with Close, rather than failed.
+func (c *Conn) closedByUser() bool {
+	select {
+	case <-c.done:
+		return c.Err() == nil
+	default:
+		return false
+	}
+}
+
+// closedErr returns the error of the closed connection.
+func (c *Conn) closedErr() error {
+	if err := c.Err(); err != nil {
+		return err
+	}
+	return ErrClosed
+}
+
+// fatal closes the connection with err, unless it's already closing.
 func (c *Conn) fatal(err error) {
 	c.mu.Lock()
-	if c.stopped {
+	if c.state != stateRunning {
 		c.mu.Unlock()
 		return
 	}
-	c.mu.Unlock()
-
-	debugf("fatal: %v", err)
-
-	c.mu.Lock()
 	if c.err == nil {
 		c.err = err
 	}
 	c.mu.Unlock()
 
-	c.Close()
+	debugf("fatal: %v", err)
+
+	// fatal is called by the reader, so it mustn't wait for itself.
+	c.close(false)
 }
 
 type Reader struct {
 	br *bufio.Reader
 	// reusable buffer for header parsing
 	hbuf [headerSize]byte
-	buf  []byte
+
+	// MaxPacketSize limits the size of the packet's body. DefaultMaxPacketSize is used if it's zero.
+	MaxPacketSize int
 }
 
+// ReadPacket reads the next packet from the stream. The packet owns its data,
+// which may be returned to the pool with Packet.Release.
+// It returns ErrPacketTooLarge if the packet's body exceeds MaxPacketSize;
+// the stream can't be read further after that.
 func (r *Reader) ReadPacket() (p Packet, err error) {
 	buf := r.hbuf[:]
 	_, err = io.ReadFull(r.br, buf)
 	if err != nil {
-		return p, fmt.Errorf("cound not read packet header: %v", err)
+		return p, fmt.Errorf("cound not read packet header: %w", err)
 	}
 	err = readPacketHeader(buf, &p)
 	if err != nil {
 		return p, fmt.Errorf("cound not parse packet header: %v", err)
 	}
 
-	n, err := r.br.Read(r.buf)
-	if err != nil {
-		return p, fmt.Errorf("cound not read packet body: %v", err)
+	maxSize := r.MaxPacketSize
+	if maxSize <= 0 {
+		maxSize = DefaultMaxPacketSize
+	}
+	if uint64(p.Len) > uint64(maxSize) {
+		return p, ErrPacketTooLarge
 	}
-	if n < int(p.Len) {
-		return p, fmt.Errorf("read less that expected: read %d, want %d", n, p.Len)
+	if p.Len == 0 {
+		return p, nil
+	}
+
+	body := getBuf(int(p.Len))
+	_, err = io.ReadFull(r.br, *body)
+	if err != nil {
+		putBuf(body)
+		return p, fmt.Errorf("cound not read packet body: %w", err)
 	}
-	p.Data = r.buf[:p.Len]
-	//debugf("< received \"???\" packet: %d, %04x %d (%d) %v", p.Seq, p.Msg, p.Len, n, p.Data)
+	p.Data = *body
+	p.buf = &packetBuf{b: body}
+	//debugf("< received \"???\" packet: %d, %04x %d %v", p.Seq, p.Msg, p.Len, p.Data)
 	return
 }
 
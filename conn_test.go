@@ -0,0 +1,270 @@
+package mrim
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"net"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+// writeTestPacket writes a packet, as the server would send it.
+func writeTestPacket(t *testing.T, conn net.Conn, seq, msg uint32, body []byte) {
+	t.Helper()
+	var buf bytes.Buffer
+	p := Packet{
+		Header: Header{Seq: seq, Msg: msg, Len: uint32(len(body))},
+		Data:   body,
+	}
+	if err := writePacket(&buf, p); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Write(buf.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// waitFor polls cond until it's true or the timeout expires.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func testBody(i int) []byte {
+	return bytes.Repeat([]byte{byte(i + 1)}, 10+i*37)
+}
+
+func TestConnBacklogPacketsStayIntact(t *testing.T) {
+	client, server := net.Pipe()
+	c := NewConn(context.Background(), client)
+	c.Run()
+	defer c.Close()
+	defer server.Close()
+
+	const n = 16
+	for i := 0; i < n; i++ {
+		writeTestPacket(t, server, uint32(i), MsgCSUserInfo, testBody(i))
+	}
+	waitFor(t, "queued packets", func() bool {
+		return len(c.recvQueue.c) == n
+	})
+
+	for i := 0; i < n; i++ {
+		p, err := c.Recv()
+		if err != nil {
+			t.Fatalf("Recv %d: %v", i, err)
+		}
+		if p.Seq != uint32(i) {
+			t.Fatalf("packet %d: got seq %d", i, p.Seq)
+		}
+		if want := testBody(i); !bytes.Equal(p.Data, want) {
+			t.Fatalf("packet %d: body is corrupted: got %v, want %v", i, p.Data, want)
+		}
+		p.Release()
+
+		// reuse the released buffers, as the reader would, to make sure
+		// the packets still waiting in the queue don't share them.
+		for j := i; j < n; j++ {
+			b := getBuf(len(testBody(j)))
+			for k := range *b {
+				(*b)[k] = 0xff
+			}
+			putBuf(b)
+		}
+	}
+}
+
+// checkGoroutines fails the test if goroutines started after base was taken don't exit.
+func checkGoroutines(t *testing.T, base int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > base {
+		if time.Now().After(deadline) {
+			buf := make([]byte, 1<<16)
+			buf = buf[:runtime.Stack(buf, true)]
+			t.Fatalf("goroutines leaked: %d, want %d\n%s", runtime.NumGoroutine(), base, buf)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// serverReader reads the packets sent by the client to the server side of the pipe.
+func serverReader(server net.Conn) <-chan Packet {
+	packets := make(chan Packet, 16)
+	go func() {
+		defer close(packets)
+		r := Reader{br: bufio.NewReader(server)}
+		for {
+			p, err := r.ReadPacket()
+			if err != nil {
+				return
+			}
+			packets <- p
+		}
+	}()
+	return packets
+}
+
+func TestConnCloseIdempotent(t *testing.T) {
+	base := runtime.NumGoroutine()
+
+	client, server := net.Pipe()
+	packets := serverReader(server)
+	c := NewConn(context.Background(), client)
+	c.Run()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.Close()
+		}()
+	}
+	wg.Wait()
+	if err := c.Close(); err != nil {
+		t.Fatalf("second Close: %v", err)
+	}
+
+	var logout int
+	for p := range packets {
+		if p.Msg == MsgCSLogout {
+			logout++
+		}
+	}
+	if logout != 1 {
+		t.Fatalf("got %d logout packets, want 1", logout)
+	}
+
+	server.Close()
+	checkGoroutines(t, base)
+}
+
+func TestConnCloseUnblocksRecv(t *testing.T) {
+	base := runtime.NumGoroutine()
+
+	client, server := net.Pipe()
+	serverReader(server)
+	c := NewConn(context.Background(), client)
+	c.Run()
+
+	errc := make(chan error, 1)
+	go func() {
+		_, err := c.Recv()
+		errc <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	c.Close()
+	if err := <-errc; err != ErrClosed {
+		t.Fatalf("Recv: got %v, want %v", err, ErrClosed)
+	}
+	if _, err := c.Recv(); err != ErrClosed {
+		t.Fatalf("Recv after Close: got %v, want %v", err, ErrClosed)
+	}
+
+	server.Close()
+	checkGoroutines(t, base)
+}
+
+func TestConnCloseUnblocksSend(t *testing.T) {
+	base := runtime.NumGoroutine()
+
+	// nobody reads the server side, so writes block.
+	client, server := net.Pipe()
+	c := NewConn(context.Background(), client)
+	c.Run()
+
+	errc := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			errc <- c.Do(context.Background(), MsgCSMessage, []byte("blocked"))
+		}()
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	c.Close()
+	for i := 0; i < 2; i++ {
+		if err := <-errc; err != ErrClosed {
+			t.Fatalf("Send: got %v, want %v", err, ErrClosed)
+		}
+	}
+	if err := c.Do(context.Background(), MsgCSMessage, nil); err != ErrClosed {
+		t.Fatalf("Send after Close: got %v, want %v", err, ErrClosed)
+	}
+
+	server.Close()
+	checkGoroutines(t, base)
+}
+
+func TestConnCloseUnblocksCall(t *testing.T) {
+	base := runtime.NumGoroutine()
+
+	client, server := net.Pipe()
+	packets := serverReader(server)
+	c := NewConn(context.Background(), client)
+	c.Run()
+
+	errc := make(chan error, 1)
+	go func() {
+		_, err := c.Call(context.Background(), MsgCSMessage, []byte("hi"), MsgCSMessageStatus)
+		errc <- err
+	}()
+	// the request has reached the server, which never replies.
+	<-packets
+
+	c.Close()
+	if err := <-errc; err != ErrClosed {
+		t.Fatalf("Call: got %v, want %v", err, ErrClosed)
+	}
+	if _, err := c.Call(context.Background(), MsgCSMessage, nil, MsgCSMessageStatus); err != ErrClosed {
+		t.Fatalf("Call after Close: got %v, want %v", err, ErrClosed)
+	}
+
+	server.Close()
+	checkGoroutines(t, base)
+}
+
+func TestConnCloseWaitsForPing(t *testing.T) {
+	base := runtime.NumGoroutine()
+
+	// nobody reads the server side, so the ping blocks in Send.
+	client, server := net.Pipe()
+	c := NewConn(context.Background(), client)
+	c.SetPingInterval(time.Millisecond)
+	c.Run()
+	time.Sleep(10 * time.Millisecond)
+
+	c.Close()
+	// the timer isn't rearmed while the ping is blocked, so no ping may run after Close.
+	buf := make([]byte, 1<<16)
+	buf = buf[:runtime.Stack(buf, true)]
+	if bytes.Contains(buf, []byte("(*Conn).ping")) {
+		t.Fatalf("ping is running after Close:\n%s", buf)
+	}
+
+	server.Close()
+	checkGoroutines(t, base)
+}
+
+func TestClientConnectAfterClose(t *testing.T) {
+	c := &Client{
+		roster: newRoster(),
+		done:   make(chan struct{}),
+	}
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Connect(context.Background(), "127.0.0.1:0", "user", "", Status{}); err != ErrClosed {
+		t.Fatalf("Connect after Close: got %v, want %v", err, ErrClosed)
+	}
+}
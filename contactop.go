@@ -0,0 +1,193 @@
+package mrim
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ContactOpError is a status of the contact operation, received with "MRIM_CS_ADD_CONTACT_ACK"
+// or "MRIM_CS_MODIFY_CONTACT_ACK".
+type ContactOpError uint32
+
+const (
+	ContactOperSuccess     ContactOpError = 0x0000
+	ContactOperError       ContactOpError = 0x0001
+	ContactOperInterr      ContactOpError = 0x0002
+	ContactOperNoSuchUser  ContactOpError = 0x0003
+	ContactOperInvalidInfo ContactOpError = 0x0004
+	ContactOperUserExists  ContactOpError = 0x0005
+	ContactOperGroupLimit  ContactOpError = 0x0006
+)
+
+var contactOpErrorText = map[ContactOpError]string{
+	ContactOperSuccess:     "success",
+	ContactOperError:       "operation failed",
+	ContactOperInterr:      "internal error",
+	ContactOperNoSuchUser:  "no such user",
+	ContactOperInvalidInfo: "invalid info",
+	ContactOperUserExists:  "user exists",
+	ContactOperGroupLimit:  "groups limit exceeded",
+}
+
+func (e ContactOpError) Error() string {
+	if text, ok := contactOpErrorText[e]; ok {
+		return "mrim: contact operation: " + text
+	}
+	return fmt.Sprintf("mrim: contact operation: unknown status %04x", uint32(e))
+}
+
+// ErrNoContact is returned when the contact isn't found in the roster.
+var ErrNoContact = errors.New("mrim: no such contact")
+
+// AddContact adds the contact with the given email into the group, and returns the new roster entry.
+func (c *Client) AddContact(ctx context.Context, email, nickname string, group int) (ct Contact, err error) {
+	ct = Contact{
+		Flags:    ContactFlagUnicodeName,
+		Group:    group,
+		Email:    email,
+		Nickname: nickname,
+	}
+	ct.ID, err = c.addContact(ctx, ct)
+	if err != nil {
+		return ct, err
+	}
+	c.roster.put(ct)
+	return ct, nil
+}
+
+// addContact sends "MRIM_CS_ADD_CONTACT" and returns the identifier assigned by the server.
+func (c *Client) addContact(ctx context.Context, ct Contact) (int, error) {
+	data, err := Marshal(csAddContact{
+		Flags:   ct.Flags,
+		GroupID: uint32(ct.Group),
+		Email:   ct.Email,
+		Name:    ct.Nickname,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	reply, err := c.currentConn().Call(ctx, MsgCSAddContact, data, MsgCSAddContactAck)
+	if err != nil {
+		return 0, err
+	}
+	defer reply.Release()
+
+	// contact_id is missing from the reply, if the operation failed.
+	var ack csAddContactAck
+	err = Unmarshal(reply.Data, &ack)
+	if status := ContactOpError(ack.Status); status != ContactOperSuccess {
+		return 0, status
+	}
+	if err != nil {
+		return 0, PacketError{reply, err}
+	}
+	return int(ack.ContactID), nil
+}
+
+// csAddContact is the body of "MRIM_CS_ADD_CONTACT".
+type csAddContact struct {
+	Flags    uint32 `mrim:"ul"`
+	GroupID  uint32 `mrim:"ul"`
+	Email    string `mrim:"lps"`
+	Name     string `mrim:"lpsw"`
+	Phones   string `mrim:"lps"`
+	AuthText string `mrim:"lps"`
+	Actions  uint32 `mrim:"ul"`
+}
+
+// csAddContactAck is the body of "MRIM_CS_ADD_CONTACT_ACK".
+type csAddContactAck struct {
+	Status    uint32 `mrim:"ul"`
+	ContactID uint32 `mrim:"ul"`
+}
+
+// ModifyContact replaces the contact's flags, group and nickname with the values of ct.
+// The contact is matched by ct.ID.
+func (c *Client) ModifyContact(ctx context.Context, ct Contact) error {
+	err := c.modifyContact(ctx, ct)
+	if err != nil {
+		return err
+	}
+	if ct.Flags&ContactFlagRemoved != 0 {
+		c.roster.remove(ct.Email)
+	} else {
+		c.roster.put(ct)
+	}
+	return nil
+}
+
+// modifyContact sends "MRIM_CS_MODIFY_CONTACT" and waits for the reply.
+func (c *Client) modifyContact(ctx context.Context, ct Contact) error {
+	data, err := Marshal(csModifyContact{
+		ID:      uint32(ct.ID),
+		Flags:   ct.Flags | ContactFlagUnicodeName,
+		GroupID: uint32(ct.Group),
+		Email:   ct.Email,
+		Name:    ct.Nickname,
+	})
+	if err != nil {
+		return err
+	}
+
+	reply, err := c.currentConn().Call(ctx, MsgCSModifyContact, data, MsgCSModifyContactAck)
+	if err != nil {
+		return err
+	}
+	defer reply.Release()
+
+	var ack csModifyContactAck
+	if err := Unmarshal(reply.Data, &ack); err != nil {
+		return PacketError{reply, err}
+	}
+	if status := ContactOpError(ack.Status); status != ContactOperSuccess {
+		return status
+	}
+	return nil
+}
+
+// csModifyContact is the body of "MRIM_CS_MODIFY_CONTACT".
+type csModifyContact struct {
+	ID      uint32 `mrim:"ul"`
+	Flags   uint32 `mrim:"ul"`
+	GroupID uint32 `mrim:"ul"`
+	Email   string `mrim:"lps"`
+	Name    string `mrim:"lpsw"`
+	Phones  string `mrim:"lps"`
+}
+
+// csModifyContactAck is the body of "MRIM_CS_MODIFY_CONTACT_ACK".
+type csModifyContactAck struct {
+	Status uint32 `mrim:"ul"`
+}
+
+// RemoveContact removes the contact with the given email from the contact list.
+func (c *Client) RemoveContact(ctx context.Context, email string) error {
+	ct, ok := c.roster.Get(email)
+	if !ok {
+		return ErrNoContact
+	}
+	ct.Flags |= ContactFlagRemoved
+	return c.ModifyContact(ctx, ct)
+}
+
+// RenameContact changes the nickname of the contact with the given email.
+func (c *Client) RenameContact(ctx context.Context, email, nickname string) error {
+	ct, ok := c.roster.Get(email)
+	if !ok {
+		return ErrNoContact
+	}
+	ct.Nickname = nickname
+	return c.ModifyContact(ctx, ct)
+}
+
+// MoveContact moves the contact with the given email into the group.
+func (c *Client) MoveContact(ctx context.Context, email string, group int) error {
+	ct, ok := c.roster.Get(email)
+	if !ok {
+		return ErrNoContact
+	}
+	ct.Group = group
+	return c.ModifyContact(ctx, ct)
+}
@@ -0,0 +1,189 @@
+package mrim
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Statuses of "MRIM_CS_CONTACT_LIST2" reply.
+const (
+	GetContactsOK     = 0x0000
+	GetContactsError  = 0x0001
+	GetContactsInterr = 0x0002
+)
+
+// firstContactID is an identifier of the first contact in the contact list.
+// Lower identifiers are reserved for groups.
+const firstContactID = MaxGroups
+
+// Group is a group of contacts.
+type Group struct {
+	ID    int
+	Flags uint32
+	Name  string
+}
+
+// Contact is an entry of the user's contact list.
+type Contact struct {
+	ID          int
+	Flags       uint32
+	Group       int // ID of the contact's group
+	Email       string
+	Nickname    string
+	ServerFlags uint32
+	Status      uint32
+	Phones      []string
+	// extended status of the contact, e.g. "status_chat".
+	XStatusURI   string
+	XStatusTitle string
+	XStatusDesc  string
+	// Features are the Feature* bits supported by the contact's client.
+	Features uint32
+	// UserAgent identifies the contact's client.
+	UserAgent string
+}
+
+// ContactList is a contact list received with "MRIM_CS_CONTACT_LIST2".
+type ContactList struct {
+	Groups   []Group
+	Contacts []Contact
+}
+
+// ContactListError is returned by ParseContactList if server failed to load the contact list.
+type ContactListError struct {
+	Status uint32
+}
+
+func (e ContactListError) Error() string {
+	return fmt.Sprintf("mrim: could not get contact list: status %d", e.Status)
+}
+
+// maskField is a value of a contact list field described by the mask.
+type maskField struct {
+	u uint32
+	s string
+}
+
+// readMask reads fields of a single contact list entry, described by the mask,
+// e.g. "us" is an UL followed by LPS.
+func readMask(r *PacketReader, mask string) ([]maskField, error) {
+	fields := make([]maskField, len(mask))
+	for i := 0; i < len(mask); i++ {
+		switch mask[i] {
+		case 'u':
+			fields[i].u = r.ReadUL()
+		case 's':
+			fields[i].s = r.ReadLPS()
+		default:
+			return nil, fmt.Errorf("unknown mask field %q", mask[i])
+		}
+	}
+	return fields, r.Err()
+}
+
+// ParseContactList decodes "MRIM_CS_CONTACT_LIST2" packet.
+// Fields, which are unknown to the client but described by the masks, are skipped.
+func ParseContactList(p Packet) (cl ContactList, err error) {
+	if p.Msg != MsgCSContactList2 {
+		return cl, PacketError{p, errUnknownPacket}
+	}
+
+	r := NewPacketReader(p.Data)
+	status := r.ReadUL()
+	if err := r.Err(); err != nil {
+		return cl, PacketError{p, fmt.Errorf("could not read status: %v", err)}
+	}
+	if status != GetContactsOK {
+		return cl, ContactListError{status}
+	}
+	groupsNum := r.ReadUL()
+	groupsMask := r.ReadLPS()
+	contactsMask := r.ReadLPS()
+	if err := r.Err(); err != nil {
+		return cl, PacketError{p, fmt.Errorf("could not read masks: %v", err)}
+	}
+	// every field takes at least 4 bytes, so entries of a non-empty mask always consume the data.
+	if groupsMask == "" || contactsMask == "" {
+		return cl, PacketError{p, fmt.Errorf("empty mask: groups %q, contacts %q", groupsMask, contactsMask)}
+	}
+	if uint64(groupsNum)*uint64(4*len(groupsMask)) > uint64(r.Remaining()) {
+		return cl, PacketError{p, fmt.Errorf("bad number of groups %d: %v", groupsNum, ErrShortPacket)}
+	}
+
+	for i := 0; i < int(groupsNum); i++ {
+		fields, err := readMask(r, groupsMask)
+		if err != nil {
+			return cl, PacketError{p, fmt.Errorf("could not read group %d: %v", i, err)}
+		}
+		g, err := groupFromFields(i, fields)
+		if err != nil {
+			return cl, PacketError{p, fmt.Errorf("could not read group %d: %v", i, err)}
+		}
+		cl.Groups = append(cl.Groups, g)
+	}
+
+	for id := firstContactID; r.Remaining() > 0; id++ {
+		fields, err := readMask(r, contactsMask)
+		if err != nil {
+			return cl, PacketError{p, fmt.Errorf("could not read contact %d: %v", id, err)}
+		}
+		ct, err := contactFromFields(id, fields)
+		if err != nil {
+			return cl, PacketError{p, fmt.Errorf("could not read contact %d: %v", id, err)}
+		}
+		cl.Contacts = append(cl.Contacts, ct)
+	}
+
+	return cl, nil
+}
+
+// groupFromFields builds a group from fields described by mask "us".
+func groupFromFields(id int, fields []maskField) (g Group, err error) {
+	g.ID = id
+	if len(fields) > 0 {
+		g.Flags = fields[0].u
+	}
+	if len(fields) > 1 {
+		g.Name, err = decodeLPSW(fields[1].s)
+	}
+	return g, err
+}
+
+// contactFromFields builds a contact from fields described by mask "uussuussssus".
+func contactFromFields(id int, fields []maskField) (ct Contact, err error) {
+	ct.ID = id
+	for i, f := range fields {
+		switch i {
+		case 0:
+			ct.Flags = f.u
+		case 1:
+			ct.Group = int(f.u)
+		case 2:
+			ct.Email = f.s
+		case 3:
+			ct.Nickname, err = decodeLPSW(f.s)
+		case 4:
+			ct.ServerFlags = f.u
+		case 5:
+			ct.Status = f.u
+		case 6:
+			if f.s != "" {
+				ct.Phones = strings.Split(f.s, ",")
+			}
+		case 7:
+			ct.XStatusURI = f.s
+		case 8:
+			ct.XStatusTitle, err = decodeLPSW(f.s)
+		case 9:
+			ct.XStatusDesc, err = decodeLPSW(f.s)
+		case 10:
+			ct.Features = f.u
+		case 11:
+			ct.UserAgent = f.s
+		}
+		if err != nil {
+			return ct, fmt.Errorf("bad field %d: %v", i, err)
+		}
+	}
+	return ct, nil
+}
@@ -0,0 +1,108 @@
+package mrim
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/narqo/mrim/internal/charset"
+)
+
+// contactListPacket builds "MRIM_CS_CONTACT_LIST2" packet from the header and raw entries' values.
+func contactListPacket(groupsNum uint32, groupsMask, contactsMask string, values ...interface{}) Packet {
+	var w PacketWriter
+	w.WriteData(uint32(GetContactsOK))
+	w.WriteData(groupsNum)
+	w.WriteData(groupsMask)
+	w.WriteData(contactsMask)
+	for _, v := range values {
+		w.WriteData(v)
+	}
+	return w.Packet(MsgCSContactList2)
+}
+
+func lpsw(s string) string {
+	return string(charset.EncodeUTF16LE(s))
+}
+
+func TestParseContactList(t *testing.T) {
+	tests := []struct {
+		name string
+		p    Packet
+		want ContactList
+		err  bool
+	}{
+		{
+			name: "normal masks",
+			p: contactListPacket(1, "us", "uussuus",
+				uint32(0), lpsw("Friends"),
+				uint32(0), uint32(0), "a@mail.ru", lpsw("Alice"), uint32(0), uint32(StatusOnline), "123,456",
+			),
+			want: ContactList{
+				Groups: []Group{{ID: 0, Name: "Friends"}},
+				Contacts: []Contact{{
+					ID:       firstContactID,
+					Email:    "a@mail.ru",
+					Nickname: "Alice",
+					Status:   StatusOnline,
+					Phones:   []string{"123", "456"},
+				}},
+			},
+		},
+		{
+			name: "unknown trailing fields",
+			p: contactListPacket(1, "usu", "uussuussssusu",
+				uint32(0), lpsw("Friends"), uint32(7),
+				uint32(0), uint32(0), "a@mail.ru", lpsw("Alice"), uint32(0), uint32(StatusOnline), "",
+				"", lpsw(""), lpsw(""), uint32(0), "ua", uint32(7),
+			),
+			want: ContactList{
+				Groups: []Group{{ID: 0, Name: "Friends"}},
+				Contacts: []Contact{{
+					ID:        firstContactID,
+					Email:     "a@mail.ru",
+					Nickname:  "Alice",
+					Status:    StatusOnline,
+					UserAgent: "ua",
+				}},
+			},
+		},
+		{
+			name: "truncated entry",
+			p: contactListPacket(0, "us", "uuss",
+				uint32(0), uint32(0), "a@mail.ru",
+			),
+			err: true,
+		},
+		{
+			name: "empty contacts mask",
+			p:    contactListPacket(0, "us", "", uint32(0)),
+			err:  true,
+		},
+		{
+			name: "empty groups mask",
+			p:    contactListPacket(0xffffffff, "", "us", uint32(0)),
+			err:  true,
+		},
+		{
+			name: "too many groups",
+			p:    contactListPacket(0xffffffff, "us", "us", uint32(0), lpsw("Friends")),
+			err:  true,
+		},
+	}
+	for _, tt := range tests {
+		cl, err := ParseContactList(tt.p)
+		if tt.err {
+			if err == nil {
+				t.Errorf("%s: want error, got %+v", tt.name, cl)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(cl, tt.want) {
+			t.Errorf("%s: got %+v, want %+v", tt.name, cl, tt.want)
+		}
+	}
+}
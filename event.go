@@ -0,0 +1,124 @@
+package mrim
+
+import (
+	"context"
+
+	"github.com/narqo/mrim/smile"
+)
+
+// Event is a packet received from the server, decoded into a typed value.
+//
+// The dynamic type of event is one of:
+//
+//	Message          - an incoming instant message ("MRIM_CS_MESSAGE_ACK")
+//	TypingEvent      - the contact is typing a message ("MRIM_CS_MESSAGE_ACK" with MessageFlagNotify)
+//	AlarmEvent       - the contact wakes the user up ("MRIM_CS_MESSAGE_ACK" with MessageFlagAlarm)
+//	AuthRequest      - a request for authorization from the contact ("MRIM_CS_MESSAGE_ACK" with MessageFlagAuthorize)
+//	Authorized       - the contact has authorized the user ("MRIM_CS_AUTHORIZE_ACK")
+//	OfflineMessage   - a message received while the user was offline ("MRIM_CS_OFFLINE_MESSAGE_ACK")
+//	ContactList      - the user's contact list sent after the login ("MRIM_CS_CONTACT_LIST2")
+//	UserStatus       - a contact's presence update ("MRIM_CS_USER_STATUS")
+//	LogoutError      - the server has terminated the session ("MRIM_CS_LOGOUT")
+//	ConnectionParams - the server has changed parameters of the connection ("MRIM_CS_CONNECTION_PARAMS")
+//	Packet           - any other packet, which doesn't have a typed representation yet
+type Event interface{}
+
+// RecvEvent reads next packet from the server and decodes it into an event.
+// Decoded events don't reference the packet's data, so the packet is released,
+// unless it's returned as is.
+// Offline messages are removed from the server once they were decoded, see Options.KeepOfflineMessages.
+func (c *Client) RecvEvent() (Event, error) {
+	return c.RecvEventContext(context.Background())
+}
+
+// RecvEventContext is like RecvEvent, but it returns when ctx is done.
+func (c *Client) RecvEventContext(ctx context.Context) (Event, error) {
+	p, err := c.RecvContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ev, err := c.decodeEvent(p)
+	if _, ok := ev.(Packet); !ok {
+		p.Release()
+	}
+	if m, ok := ev.(OfflineMessage); ok && err == nil && !c.keepOffline {
+		// the message is handed to the application, so it's safe to remove it from the server.
+		if err := c.DeleteOfflineMessage(ctx, m.UIDL); err != nil {
+			c.logger.Printf("could not delete offline message: %v\n", err)
+		}
+	}
+	return ev, err
+}
+
+func (c *Client) decodeEvent(p Packet) (Event, error) {
+	switch p.Msg {
+	case MsgCSMessageAck:
+		m, err := ParseMessage(p)
+		if err != nil {
+			return nil, err
+		}
+		if m.Flags&MessageFlagNotify != 0 {
+			return TypingEvent{From: m.From}, nil
+		}
+		if m.Flags&MessageFlagAlarm != 0 {
+			return AlarmEvent{From: m.From}, nil
+		}
+		if m.Flags&MessageFlagAuthorize != 0 {
+			req, err := ParseAuthRequest(m)
+			if err != nil {
+				return nil, err
+			}
+			return req, nil
+		}
+		if c.smiles {
+			m.Text = smile.Decode(m.Text)
+		}
+		return m, nil
+
+	case MsgCSLogout:
+		le, err := ParseLogout(p)
+		if err != nil {
+			return nil, err
+		}
+		return le, nil
+
+	case MsgCSConnectionParams:
+		cp, err := ParseConnectionParams(p)
+		if err != nil {
+			return nil, err
+		}
+		return cp, nil
+
+	case MsgCSAuthorizeAck:
+		a, err := ParseAuthorized(p)
+		if err != nil {
+			return nil, err
+		}
+		return a, nil
+
+	case MrimCSOfflineMessageAck:
+		m, err := ParseOfflineMessage(p)
+		if err != nil {
+			return nil, err
+		}
+		if c.smiles {
+			m.Text = smile.Decode(m.Text)
+		}
+		return m, nil
+
+	case MsgCSContactList2:
+		cl, err := ParseContactList(p)
+		if err != nil {
+			return nil, err
+		}
+		return cl, nil
+
+	case MsgCSUserStatus:
+		us, err := ParseUserStatus(p)
+		if err != nil {
+			return nil, err
+		}
+		return us, nil
+	}
+	return p, nil
+}
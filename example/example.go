@@ -28,7 +28,7 @@ func main() {
 
 	errc := make(chan error, 1)
 	go func() {
-		c := make(chan os.Signal)
+		c := make(chan os.Signal, 1)
 		signal.Notify(c, syscall.SIGINT)
 		errc <- fmt.Errorf("%s", <-c)
 	}()
@@ -46,7 +46,7 @@ func main() {
 		Username:  *username,
 		Password:  *password,
 		UserAgent: mrim.DefaultUserAgent,
-		Status:    mrim.StatusOnline,
+		Status:    mrim.Status{Code: mrim.StatusOnline},
 	}
 	c, err := mrim.NewClient(ctx, opt)
 	if err != nil {
@@ -63,7 +63,7 @@ func main() {
 func readChat(ctx context.Context, c *mrim.Client) {
 	log.Println("read chat")
 	for {
-		p, err := c.Recv()
+		ev, err := c.RecvEvent()
 		if err != nil {
 			if err == context.Canceled {
 				log.Printf("context was canceld: %v\n", err)
@@ -72,14 +72,20 @@ func readChat(ctx context.Context, c *mrim.Client) {
 			log.Printf("could not read reply: %v\n", err)
 			continue
 		}
-		log.Printf("received packet: %d, %04x %v\n", p.Seq, p.Msg, p.Data)
+		switch ev := ev.(type) {
+		case mrim.Message:
+			log.Printf("received message from %s: %s\n", ev.From, ev.Text)
+		case mrim.Packet:
+			log.Printf("received packet: %d, %04x %v\n", ev.Seq, ev.Msg, ev.Data)
+		}
 	}
 }
 
 func spamChat(ctx context.Context, c *mrim.Client, to string) {
 	log.Println("spam chat")
 	for i := 0; i < 5; i++ {
-		err := sendMsg(ctx, c, to, fmt.Sprintf("Поехали! Test message %d", i))
+		msg := fmt.Sprintf("Поехали! Test message %d", i)
+		err := c.SendMessage(ctx, to, msg, &mrim.MessageOptions{Flags: mrim.MessageFlagNorecv})
 		if err != nil {
 			log.Printf("could not send message: %v\n", err)
 			continue
@@ -87,19 +93,3 @@ func spamChat(ctx context.Context, c *mrim.Client, to string) {
 		time.Sleep(3 * time.Second)
 	}
 }
-
-func sendMsg(ctx context.Context, c *mrim.Client, to, msg string) error {
-	msgRTF := []byte{' '}
-
-	var p mrim.PacketWriter
-	p.WriteData(mrim.MessageFlagNorecv) // flags
-	p.WriteData(to)                     // to
-	p.WriteData(msg)                    // message
-	p.WriteData(msgRTF)                 // rtf message
-
-	err := c.Send(ctx, p.Packet(mrim.MsgCSMessage))
-	if err != nil {
-		log.Fatal(err)
-	}
-	return nil
-}
@@ -0,0 +1,83 @@
+package mrim
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrNoGroup is returned when the group isn't found in the roster.
+var ErrNoGroup = errors.New("mrim: no such group")
+
+// groupFlags packs the group's index into its flags.
+func groupFlags(index int) uint32 {
+	return ContactFlagGroup | ContactFlagUnicodeName | uint32(index)<<24
+}
+
+// AddGroup adds new group into the contact list.
+// The group is requested at the lowest index, which isn't used by other groups.
+// The index assigned by the server is returned as the group's ID.
+// It returns ContactOperGroupLimit error if the contact list already has MaxGroups groups.
+func (c *Client) AddGroup(ctx context.Context, name string) (g Group, err error) {
+	index, ok := freeGroupIndex(c.roster.Groups())
+	if !ok {
+		return g, ContactOperGroupLimit
+	}
+
+	id, err := c.addContact(ctx, Contact{Flags: groupFlags(index), Nickname: name})
+	if err != nil {
+		return g, err
+	}
+	g = Group{
+		ID:    id,
+		Flags: groupFlags(id),
+		Name:  name,
+	}
+	c.roster.putGroup(g)
+	return g, nil
+}
+
+// freeGroupIndex returns the lowest index, which isn't taken by groups.
+// Indexes of groups marked with ContactFlagRemoved are free.
+func freeGroupIndex(groups []Group) (index int, ok bool) {
+	var used [MaxGroups]bool
+	for _, g := range groups {
+		if g.Flags&ContactFlagRemoved == 0 && g.ID >= 0 && g.ID < MaxGroups {
+			used[g.ID] = true
+		}
+	}
+	for i, u := range used {
+		if !u {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// RenameGroup changes the name of the group.
+func (c *Client) RenameGroup(ctx context.Context, id int, name string) error {
+	g, ok := c.roster.Group(id)
+	if !ok {
+		return ErrNoGroup
+	}
+	g.Name = name
+	err := c.modifyContact(ctx, Contact{ID: g.ID, Flags: groupFlags(g.ID), Nickname: g.Name})
+	if err != nil {
+		return err
+	}
+	c.roster.putGroup(g)
+	return nil
+}
+
+// RemoveGroup removes the group from the contact list.
+func (c *Client) RemoveGroup(ctx context.Context, id int) error {
+	g, ok := c.roster.Group(id)
+	if !ok {
+		return ErrNoGroup
+	}
+	err := c.modifyContact(ctx, Contact{ID: g.ID, Flags: groupFlags(g.ID) | ContactFlagRemoved, Nickname: g.Name})
+	if err != nil {
+		return err
+	}
+	c.roster.removeGroup(g.ID)
+	return nil
+}
@@ -0,0 +1,84 @@
+package mrim
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestFreeGroupIndex(t *testing.T) {
+	full := make([]Group, MaxGroups)
+	for i := range full {
+		full[i] = Group{ID: i}
+	}
+
+	tests := []struct {
+		name   string
+		groups []Group
+		index  int
+		ok     bool
+	}{
+		{"empty", nil, 0, true},
+		{"after last", []Group{{ID: 0}, {ID: 1}}, 2, true},
+		{"gap after removal", []Group{{ID: 0}, {ID: 2}}, 1, true},
+		{"removed flag", []Group{{ID: 0}, {ID: 1, Flags: ContactFlagRemoved}, {ID: 2}}, 1, true},
+		{"full", full, 0, false},
+	}
+	for _, tt := range tests {
+		index, ok := freeGroupIndex(tt.groups)
+		if index != tt.index || ok != tt.ok {
+			t.Errorf("%s: got (%d, %v), want (%d, %v)", tt.name, index, ok, tt.index, tt.ok)
+		}
+	}
+}
+
+func TestAddGroup(t *testing.T) {
+	c, server := newTestClient(t, DefaultRecvQueueSize)
+	packets := serverReader(server)
+	defer server.Close()
+	defer c.Close()
+
+	c.roster.putGroup(Group{ID: 0, Flags: groupFlags(0), Name: "General"})
+
+	type result struct {
+		g   Group
+		err error
+	}
+	res := make(chan result, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		g, err := c.AddGroup(ctx, "Friends")
+		res <- result{g, err}
+	}()
+
+	p := <-packets
+	if p.Msg != MsgCSAddContact {
+		t.Fatalf("got packet 0x%x, want \"MRIM_CS_ADD_CONTACT\"", p.Msg)
+	}
+	var req csAddContact
+	if err := Unmarshal(p.Data, &req); err != nil {
+		t.Fatal(err)
+	}
+	if req.Flags != groupFlags(1) {
+		t.Errorf("requested flags 0x%x, want 0x%x", req.Flags, groupFlags(1))
+	}
+	// the server picks the index on its own.
+	data, err := Marshal(csAddContactAck{Status: uint32(ContactOperSuccess), ContactID: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestPacket(t, server, p.Seq, MsgCSAddContactAck, data)
+
+	r := <-res
+	if r.err != nil {
+		t.Fatal(r.err)
+	}
+	want := Group{ID: 3, Flags: groupFlags(3), Name: "Friends"}
+	if r.g != want {
+		t.Errorf("got %+v, want %+v", r.g, want)
+	}
+	if rg, ok := c.roster.Group(3); !ok || rg != want {
+		t.Errorf("roster group: got %+v, %v, want %+v", rg, ok, want)
+	}
+}
@@ -0,0 +1,49 @@
+package mrim
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrLoggedOut is matched by LogoutError with errors.Is.
+var ErrLoggedOut = errors.New("mrim: logged out by server")
+
+// LogoutError is the error of the connection, which was terminated by the server with "MRIM_CS_LOGOUT",
+// e.g. because the same account has logged in elsewhere.
+type LogoutError struct {
+	Reason uint32
+	// NoRelogin is set, if the server asks the client not to log in again.
+	NoRelogin bool
+}
+
+func (e LogoutError) Error() string {
+	if e.NoRelogin {
+		return fmt.Sprintf("mrim: logged out by server: reason 0x%x, no relogin", e.Reason)
+	}
+	return fmt.Sprintf("mrim: logged out by server: reason 0x%x", e.Reason)
+}
+
+// Is reports whether target is ErrLoggedOut.
+func (e LogoutError) Is(target error) bool {
+	return target == ErrLoggedOut
+}
+
+// ParseLogout decodes "MRIM_CS_LOGOUT" packet.
+func ParseLogout(p Packet) (e LogoutError, err error) {
+	if p.Msg != MsgCSLogout {
+		return e, PacketError{p, errUnknownPacket}
+	}
+
+	var body csLogout
+	if err := Unmarshal(p.Data, &body); err != nil {
+		return e, PacketError{p, err}
+	}
+	e.Reason = body.Reason
+	e.NoRelogin = e.Reason&LogoutFlagNoRelogin != 0
+	return e, nil
+}
+
+// csLogout is the body of "MRIM_CS_LOGOUT".
+type csLogout struct {
+	Reason uint32 `mrim:"ul"`
+}
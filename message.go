@@ -0,0 +1,195 @@
+package mrim
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/narqo/mrim/rtf"
+	"github.com/narqo/mrim/smile"
+)
+
+// Message is an instant message sent with "MRIM_CS_MESSAGE" or received with "MRIM_CS_MESSAGE_ACK".
+type Message struct {
+	// ID is a server assigned identifier of the incoming message (msg_id).
+	ID    uint32
+	Flags uint32
+	From  string
+	To    string
+	Text  string
+	// RTF is a packed rtf version of the message, as it was received from the server.
+	RTF []byte
+}
+
+// RichText unpacks rtf version of the message, which holds the message's formatting.
+func (m Message) RichText() (rtf.Document, error) {
+	return rtf.Decode(m.RTF)
+}
+
+// MessageOptions configures outgoing messages.
+type MessageOptions struct {
+	// Flags are the MessageFlag* bits.
+	// The text is sent in UTF-16LE with MessageFlagV1p16, unless MessageFlagCP1251 is set.
+	Flags uint32
+	// RTF is a packed rtf version of the message. A single space is sent if it's empty.
+	RTF []byte
+	// Format, if set, is used to build rtf version of the message, unless RTF is set.
+	Format *rtf.Format
+}
+
+// MessageStatus is a delivery status of the message, received with "MRIM_CS_MESSAGE_STATUS".
+type MessageStatus uint32
+
+const (
+	MessageDelivered             MessageStatus = 0x0000
+	MessageRejectedNoUser        MessageStatus = 0x8001
+	MessageRejectedInterr        MessageStatus = 0x8003
+	MessageRejectedLimitExceeded MessageStatus = 0x8004
+	MessageRejectedTooLarge      MessageStatus = 0x8005
+	MessageRejectedDenyOffmsg    MessageStatus = 0x8006
+)
+
+var messageStatusText = map[MessageStatus]string{
+	MessageDelivered:             "delivered",
+	MessageRejectedNoUser:        "no such user",
+	MessageRejectedInterr:        "internal error",
+	MessageRejectedLimitExceeded: "limit exceeded",
+	MessageRejectedTooLarge:      "message too large",
+	MessageRejectedDenyOffmsg:    "offline messages are not supported",
+}
+
+func (s MessageStatus) String() string {
+	if text, ok := messageStatusText[s]; ok {
+		return text
+	}
+	return fmt.Sprintf("unknown status %04x", uint32(s))
+}
+
+// Error implements error interface, so statuses other than MessageDelivered can be returned as errors.
+func (s MessageStatus) Error() string {
+	return "mrim: message rejected: " + s.String()
+}
+
+// SendMessage sends text message to the contact.
+//
+// Unless MessageFlagNorecv is set in opt, SendMessage waits for "MRIM_CS_MESSAGE_STATUS" reply
+// and returns MessageStatus as an error if the message wasn't delivered. Use ctx to limit the time of waiting.
+func (c *Client) SendMessage(ctx context.Context, to, text string, opt *MessageOptions) error {
+	if opt == nil {
+		opt = &MessageOptions{}
+	}
+	if c.smiles {
+		text = smile.Encode(text)
+	}
+	m := Message{
+		Flags: opt.Flags,
+		To:    to,
+		Text:  text,
+		RTF:   opt.RTF,
+	}
+	if m.Flags&MessageFlagCP1251 == 0 {
+		// send the text in unicode, unless the caller explicitly asked for CP1251.
+		m.Flags |= MessageFlagV1p16
+	}
+	if len(m.RTF) == 0 && opt.Format != nil {
+		var err error
+		m.RTF, err = rtf.Encode(text, opt.Format.Font, opt.Format.Color, opt.Format.BgColor)
+		if err != nil {
+			return err
+		}
+		m.Flags |= MessageFlagRTF
+	}
+	p := packetCsMessage(m)
+	if m.Flags&MessageFlagNorecv != 0 {
+		return c.Send(ctx, p)
+	}
+
+	reply, err := c.currentConn().Call(ctx, p.Msg, p.Data, MsgCSMessageStatus)
+	if err != nil {
+		return err
+	}
+	defer reply.Release()
+
+	var ms csMessageStatus
+	if err := Unmarshal(reply.Data, &ms); err != nil {
+		return PacketError{reply, err}
+	}
+	if status := MessageStatus(ms.Status); status != MessageDelivered {
+		return status
+	}
+	return nil
+}
+
+// csMessageStatus is the body of "MRIM_CS_MESSAGE_STATUS".
+type csMessageStatus struct {
+	Status uint32 `mrim:"ul"`
+}
+
+// csMessageRecv is the body of "MRIM_CS_MESSAGE_RECV".
+type csMessageRecv struct {
+	From  string `mrim:"lps"`
+	MsgID uint32 `mrim:"ul"`
+}
+
+// AckMessage sends "MRIM_CS_MESSAGE_RECV", which confirms that incoming message m was received.
+// Messages with MessageFlagNorecv don't require acknowledgement, and AckMessage does nothing for them.
+//
+// Incoming messages are acknowledged automatically, unless Options.NoAutoAck is set.
+func (c *Client) AckMessage(ctx context.Context, m Message) error {
+	if m.Flags&MessageFlagNorecv != 0 {
+		return nil
+	}
+	data, err := Marshal(csMessageRecv{m.From, m.ID})
+	if err != nil {
+		return err
+	}
+	return c.currentConn().Do(ctx, MsgCSMessageRecv, data)
+}
+
+func packetCsMessage(m Message) Packet {
+	rtf := m.RTF
+	if len(rtf) == 0 {
+		rtf = []byte{' '}
+	}
+
+	pw := PacketWriter{}
+	pw.WriteData(m.Flags)
+	pw.WriteData(m.To)
+	if isUnicodeMessage(m.Flags) {
+		pw.WriteData(LPSW(m.Text))
+	} else {
+		pw.WriteData(LPSA(m.Text))
+	}
+	pw.WriteData(rtf)
+	return pw.Packet(MsgCSMessage)
+}
+
+// isUnicodeMessage reports whether the message's text is UTF-16LE encoded.
+// The text of other messages is CP1251 encoded.
+func isUnicodeMessage(flags uint32) bool {
+	return flags&MessageFlagV1p16 != 0 && flags&MessageFlagCP1251 == 0
+}
+
+// ParseMessage decodes "MRIM_CS_MESSAGE_ACK" packet into a message.
+func ParseMessage(p Packet) (m Message, err error) {
+	if p.Msg != MsgCSMessageAck {
+		return m, PacketError{p, errUnknownPacket}
+	}
+
+	r := NewPacketReader(p.Data)
+	m.ID = r.ReadUL()
+	m.Flags = r.ReadUL()
+	m.From = r.ReadLPS()
+	if isUnicodeMessage(m.Flags) {
+		m.Text = r.ReadLPSW()
+	} else {
+		m.Text = r.ReadLPSA()
+	}
+	// rtf part is optional and is missing from some messages, e.g. from the system.
+	if r.Remaining() > 0 {
+		m.RTF = []byte(r.ReadLPS())
+	}
+	if err := r.Err(); err != nil {
+		return m, PacketError{p, fmt.Errorf("could not read message: %v", err)}
+	}
+	return m, nil
+}
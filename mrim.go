@@ -3,13 +3,13 @@ package mrim
 import (
 	"bytes"
 	"context"
-	"encoding/binary"
 	"errors"
 	"fmt"
 	"log"
 	"net"
 	"os"
 	"strconv"
+	"sync"
 	"time"
 )
 
@@ -41,28 +41,141 @@ type Options struct {
 	Addr      string
 	Username  string
 	Password  string
-	Status    uint32
+	Status    Status
 	UserAgent string
 	Lang      string // (>=1.16)
 	Logger    Logger
+	// NoAutoAck disables automatic acknowledgement of incoming messages with "MRIM_CS_MESSAGE_RECV".
+	// Otherwise a message is acknowledged when Recv or RecvEvent returns it.
+	// Use Client.AckMessage to acknowledge messages manually.
+	NoAutoAck bool
+	// Features are the Feature* bits advertised to the server at login, e.g. FeatureWakeup.
+	Features uint32
+	// TranslateSmiles enables translation between smile tags and Unicode emoji in messages' text.
+	// FeatureBaseSmiles and FeatureAdvancedSmiles are advertised at login, if it's set.
+	TranslateSmiles bool
+	// MaxPacketSize limits the size of incoming packets. DefaultMaxPacketSize is used if it's zero.
+	MaxPacketSize int
+	// KeepOfflineMessages disables automatic removal of offline messages from the server,
+	// once they were decoded and returned by RecvEvent.
+	// Offline messages returned by Recv as raw packets are never removed automatically.
+	// Use Client.DeleteOfflineMessage to delete messages manually.
+	KeepOfflineMessages bool
+	// RecvQueueSize is the number of received packets queued for Recv. DefaultRecvQueueSize is used if it's zero.
+	RecvQueueSize int
+	// Overflow is the policy applied to incoming packets, when the receive queue is full.
+	// By default, the incoming packets are dropped, see OverflowDropNewest.
+	Overflow OverflowPolicy
+	// IdlePings is the number of ping intervals without inbound traffic, after which
+	// the connection is considered dead and fails with ErrTimeout. Zero disables the check.
+	IdlePings int
+	// WriteTimeout limits writes, which context has no deadline. Zero means no limit.
+	WriteTimeout time.Duration
+	// Reconnect enables automatic reconnection, when the connection to the server is lost.
+	// The session is restored with the last status set.
+	Reconnect bool
+	// ReconnectMinDelay and ReconnectMaxDelay bound the backoff between reconnection attempts.
+	// DefaultReconnectMinDelay and DefaultReconnectMaxDelay are used if they are zero.
+	ReconnectMinDelay time.Duration
+	ReconnectMaxDelay time.Duration
+	// OnConnState, if set, is called on every change of the connection's state.
+	// It's called from the client's goroutines, so it must not block.
+	OnConnState func(ConnStateEvent)
 }
 
 type Client struct {
 	conn   *Conn
 	logger Logger
+	roster *Roster
 
 	loginAddr net.Addr
 
+	// ctx is the context of the client's session, used for reconnection.
+	// It's canceled on Close.
+	ctx    context.Context
+	cancel context.CancelFunc
+	// credentials are kept to restore the session.
+	addr     string
+	username string
+	password string
+
 	userAgent string
 	lang      string
+	autoAck   bool
+	features  uint32
+	smiles    bool
+	// maxPacketSize is passed to conn's reader.
+	maxPacketSize int
+	// keepOffline disables removal of offline messages once they were returned by RecvEvent.
+	keepOffline bool
+	// parameters of conn's receive queue.
+	recvQueueSize int
+	overflow      OverflowPolicy
+	// timeouts passed to conn.
+	idlePings    int
+	writeTimeout time.Duration
+
+	reconnect         bool
+	reconnectMinDelay time.Duration
+	reconnectMaxDelay time.Duration
+	onConnState       func(ConnStateEvent)
+	// reconnected is closed when the lost connection is replaced with a new one,
+	// or when the client stops reconnecting.
+	reconnected chan struct{}
+	// err is the reason the client has stopped reconnecting.
+	err error
+	// dropped counts the packets dropped by the previous connections.
+	dropped map[uint32]uint64
+	// done is closed when the client is closed.
+	done      chan struct{}
+	closeOnce sync.Once
+	// wg waits for the reconnection supervisor.
+	wg sync.WaitGroup
+
 	// helloAck becomes true after MRIM_CS_HELLO_ACK received.
 	helloAck bool
+
+	mu sync.Mutex
+	// status is the last status set by the user.
+	status Status
+	// typing keeps the time of the last typing notification sent to the contact.
+	typing map[string]time.Time
 }
 
 func NewClient(ctx context.Context, opt *Options) (*Client, error) {
 	c := &Client{
+		roster:    newRoster(),
+		typing:    make(map[string]time.Time),
 		userAgent: opt.UserAgent,
 		lang:      opt.Lang,
+		autoAck:   !opt.NoAutoAck,
+		features:  opt.Features,
+		smiles:    opt.TranslateSmiles,
+
+		maxPacketSize: opt.MaxPacketSize,
+
+		keepOffline: opt.KeepOfflineMessages,
+
+		recvQueueSize: opt.RecvQueueSize,
+		overflow:      opt.Overflow,
+
+		idlePings:    opt.IdlePings,
+		writeTimeout: opt.WriteTimeout,
+
+		reconnect:         opt.Reconnect,
+		reconnectMinDelay: opt.ReconnectMinDelay,
+		reconnectMaxDelay: opt.ReconnectMaxDelay,
+		onConnState:       opt.OnConnState,
+		reconnected:       make(chan struct{}),
+		done:              make(chan struct{}),
+		dropped:           make(map[uint32]uint64),
+	}
+
+	if c.reconnectMinDelay <= 0 {
+		c.reconnectMinDelay = DefaultReconnectMinDelay
+	}
+	if c.reconnectMaxDelay <= 0 {
+		c.reconnectMaxDelay = DefaultReconnectMaxDelay
 	}
 
 	if opt.UserAgent != "" {
@@ -77,6 +190,10 @@ func NewClient(ctx context.Context, opt *Options) (*Client, error) {
 		c.lang = LangRu
 	}
 
+	if c.smiles {
+		c.features |= FeatureBaseSmiles | FeatureAdvancedSmiles
+	}
+
 	if opt.Logger != nil {
 		c.logger = opt.Logger
 	} else {
@@ -90,7 +207,12 @@ func NewClient(ctx context.Context, opt *Options) (*Client, error) {
 	return c, nil
 }
 
-func (c *Client) Connect(ctx context.Context, address, username, password string, status uint32) error {
+func (c *Client) Connect(ctx context.Context, address, username, password string, status Status) error {
+	select {
+	case <-c.done:
+		return ErrClosed
+	default:
+	}
 	if c.conn != nil {
 		return errors.New("mrim: already connected")
 	}
@@ -100,21 +222,49 @@ func (c *Client) Connect(ctx context.Context, address, username, password string
 	}
 
 	err = c.Hello(ctx)
-	if err != nil {
-		return err
+	if err == nil {
+		err = c.Auth(ctx, username, password, status)
 	}
-
-	err = c.Auth(ctx, username, password, status)
 	if err != nil {
+		c.conn.Close()
+		c.conn = nil
+		c.helloAck = false
 		return err
 	}
 
+	c.ctx, c.cancel = context.WithCancel(ctx)
+	c.addr = address
+	c.username = username
+	c.password = password
+
 	// after this point conn is meant to be established, run the conn reader
-	c.conn.Run()
+	c.runConn(c.conn)
+
+	if c.reconnect {
+		c.wg.Add(1)
+		go c.supervise(c.conn)
+	}
+	c.notifyConnState(StateConnected, nil, 0)
 
 	return nil
 }
 
+// runConn starts the reader of the established connection.
+func (c *Client) runConn(conn *Conn) {
+	conn.handler = func(p Packet) {
+		c.handle(conn, p)
+	}
+	conn.Run()
+}
+
+// currentConn returns the connection to the server.
+// The connection is replaced, when the client reconnects.
+func (c *Client) currentConn() *Conn {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conn
+}
+
 func parseLoginAddr(data []byte) (net.Addr, error) {
 	d := bytes.IndexByte(data, ':')
 	if d == -1 {
@@ -149,50 +299,92 @@ func dial(ctx context.Context, addr string, timeout time.Duration) (*Conn, error
 // dial initializes net connection to login host retrieved from server address.
 // TODO(varankinv): refactor dial()
 func (c *Client) dial(ctx context.Context, address string) error {
-	nconn, err := net.DialTimeout("tcp", address, DefaultInitTimeout)
+	conn, loginAddr, err := c.dialLogin(ctx, address)
 	if err != nil {
 		return err
 	}
+	c.loginAddr = loginAddr
+	c.conn = conn
+	return nil
+}
+
+// dialLogin asks the server at address for the login host and connects to it.
+func (c *Client) dialLogin(ctx context.Context, address string) (*Conn, net.Addr, error) {
+	dialer := &net.Dialer{
+		Timeout: DefaultInitTimeout,
+	}
+	nconn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, nil, err
+	}
 	defer nconn.Close()
 
+	deadline := time.Now().Add(DefaultInitTimeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	nconn.SetReadDeadline(deadline)
+
+	// the balancer replies immediately, so closing the connection is enough to interrupt the read.
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			nconn.Close()
+		case <-stop:
+		}
+	}()
+
 	data := make([]byte, 24)
 	if _, err := nconn.Read(data); err != nil {
-		return err
+		if ctx.Err() != nil {
+			return nil, nil, ctx.Err()
+		}
+		return nil, nil, err
 	}
 	loginAddr, err := parseLoginAddr(data)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 
 	c.logger.Printf("loggin addr: %s\n", loginAddr.String())
 
 	conn, err := dial(ctx, loginAddr.String(), DefaultTimeout)
 	if err != nil {
-		return fmt.Errorf("could not dial to login addr: %v", err)
+		return nil, nil, fmt.Errorf("could not dial to login addr: %v", err)
 	}
 
 	select {
 	case <-ctx.Done():
 		conn.Close()
-		return ctx.Err()
+		return nil, nil, ctx.Err()
 	default:
 	}
 
-	c.loginAddr = loginAddr
-	c.conn = conn
+	conn.MaxPacketSize = c.maxPacketSize
+	conn.SetRecvQueue(c.recvQueueSize, c.overflow)
+	conn.IdlePings = c.idlePings
+	conn.WriteTimeout = c.writeTimeout
 
-	return nil
+	return conn, loginAddr, nil
 }
 
-func (c *Client) Close() error {
-	if c.conn == nil {
-		return nil
-	}
-	err := c.conn.Close()
-	if err != nil {
-		c.conn = nil
-	}
-	return nil
+// Close logs out and closes the connection to the server.
+// Pending and future calls of Recv, as well as Connect, return ErrClosed.
+// It's safe to call Close more than once.
+func (c *Client) Close() (err error) {
+	c.closeOnce.Do(func() {
+		close(c.done)
+	})
+	if conn := c.currentConn(); conn != nil {
+		err = conn.Close()
+	}
+	if c.cancel != nil {
+		c.cancel()
+	}
+	c.wg.Wait()
+	return err
 }
 
 // Hello sends "MRIM_CS_HELLO" message and reads the reply.
@@ -201,44 +393,45 @@ func (c *Client) Hello(ctx context.Context) (err error) {
 	if c.helloAck {
 		return errors.New("mrim: repeative hello call")
 	}
-	return c.hello(ctx)
-}
-
-// hello is an idempotent version of Hello.
-// It process the response and updates underlying conn's pingInterval according to reply.
-func (c *Client) hello(ctx context.Context) (err error) {
-	if c.helloAck {
-		return nil
+	err = c.hello(ctx, c.conn)
+	if err != nil {
+		return err
 	}
+	c.helloAck = true
+	return nil
+}
 
+// hello does the hello exchange on the connection, which reader hasn't been started yet.
+// It process the response and sets conn's ping interval according to reply.
+func (c *Client) hello(ctx context.Context, conn *Conn) (err error) {
 	var p Packet
 	p.Header.Msg = MsgCSHello
 
-	err = c.conn.Send(ctx, p)
+	err = conn.Send(ctx, p)
 	if err != nil {
 		return err
 	}
 
 	// read reply here because readLoop hasn't been started yet.
-	p, err = c.conn.ReadPacket()
+	p, err = conn.readContext(ctx)
 	if err != nil {
 		return err
 	}
+	defer p.Release()
 
 	if p.Msg != MsgCSHelloAck {
 		return PacketError{p, errUnknownPacket}
 	}
 
-	c.helloAck = true
-
-	pingInterval := binary.LittleEndian.Uint32(p.Data)
+	r := NewPacketReader(p.Data)
+	pingInterval := r.ReadUL()
+	if err := r.Err(); err != nil {
+		return PacketError{p, fmt.Errorf("could not read ping interval: %v", err)}
+	}
 	c.logger.Printf("> received \"MRIM_CS_HELLO_ACK\" packet: %d, %04x, ping %d\n", p.Seq, p.Msg, pingInterval)
 
 	if pingInterval > 0 {
-		// FIXME(varankinv): think of a better way of setting pingInterval.
-		c.conn.mu.Lock()
-		c.conn.pingInterval = time.Duration(pingInterval) * time.Second
-		c.conn.mu.Unlock()
+		conn.SetPingInterval(time.Duration(pingInterval) * time.Second)
 	}
 
 	return nil
@@ -253,35 +446,52 @@ func (e AuthError) Error() string {
 }
 
 // Auth sends "MRIM_CS_LOGIN2" and reads the reply.
-func (c *Client) Auth(ctx context.Context, username, password string, status uint32) (err error) {
+func (c *Client) Auth(ctx context.Context, username, password string, status Status) (err error) {
 	if !c.helloAck {
 		return ErrNoHello
 	}
+	return c.login(ctx, c.conn, username, password, status)
+}
 
-	pCsLogin2 := c.packetCsLogin2(ctx, username, password, status)
-	err = c.conn.Send(ctx, pCsLogin2)
+// login does the authorization on the connection, which reader hasn't been started yet.
+func (c *Client) login(ctx context.Context, conn *Conn, username, password string, status Status) (err error) {
+	pCsLogin2, err := c.packetCsLogin2(ctx, username, password, status)
+	if err != nil {
+		return err
+	}
+	err = conn.Send(ctx, pCsLogin2)
 	if err != nil {
 		return err
 	}
 
 	// read reply here because readLoop hasn't been started yet.
-	p, err := c.conn.ReadPacket()
+	p, err := conn.readContext(ctx)
 	if err != nil {
 		return err
 	}
+	defer p.Release()
 
 	switch p.Msg {
 	case MsgCSLoginAck:
 		c.logger.Printf("> received \"MRIM_CS_LOGIN_ACK\" packet: %d, %04x\n", p.Seq, p.Msg)
+		c.setStatus(status)
 
 	case MsgCSLoginRej:
-		reason, err := unpackLPS(p.Data)
-		if err != nil {
+		r := NewPacketReader(p.Data)
+		reason := r.ReadLPSA()
+		if err := r.Err(); err != nil {
 			return fmt.Errorf("mrim: cound not read auth rejection reason: %v", err)
 		}
 		c.logger.Printf("> received \"MRIM_CS_LOGIN_REJ\" packet: %d, %04x, reason %q\n", p.Seq, p.Msg, reason)
 		return AuthError{reason}
 
+	case MsgCSLogout:
+		le, err := ParseLogout(p)
+		if err != nil {
+			return err
+		}
+		return le
+
 	default:
 		return PacketError{p, errUnknownPacket}
 	}
@@ -289,30 +499,164 @@ func (c *Client) Auth(ctx context.Context, username, password string, status uin
 	return nil
 }
 
-func (c *Client) packetCsLogin2(ctx context.Context, username, password string, status uint32) Packet {
-	pw := PacketWriter{}
-	pw.WriteData(username)
-	pw.WriteData(password)
-	pw.WriteData(status)
-	pw.WriteData(0) // spec_status_uri
-	pw.WriteData(0) // status_title
-	pw.WriteData(0) // status_desc
-	pw.WriteData(0) // features
-	pw.WriteData(c.userAgent)
-	//pw.WriteData(c.lang)
-	pw.WriteData([]byte{' '}) // client_desc
-	return pw.Packet(MsgCSLogin2)
+// csLogin2 is the body of "MRIM_CS_LOGIN2".
+type csLogin2 struct {
+	Login      string `mrim:"lps"`
+	Password   string `mrim:"lps,cp1251"`
+	Status     uint32 `mrim:"ul"`
+	URI        string `mrim:"lps,cp1251"`
+	Title      string `mrim:"lpsw"`
+	Desc       string `mrim:"lpsw"`
+	Features   uint32 `mrim:"ul"`
+	UserAgent  string `mrim:"lps"`
+	Lang       string `mrim:"-"` // not sent before 1.16
+	ClientDesc string `mrim:"lps"`
+}
+
+func (c *Client) packetCsLogin2(ctx context.Context, username, password string, status Status) (p Packet, err error) {
+	data, err := Marshal(csLogin2{
+		Login:      username,
+		Password:   password,
+		Status:     status.code(),
+		URI:        status.URI,
+		Title:      status.Title,
+		Desc:       status.Desc,
+		Features:   c.features,
+		UserAgent:  c.userAgent,
+		ClientDesc: " ",
+	})
+	if err != nil {
+		return p, err
+	}
+	p.Msg = MsgCSLogin2
+	p.Len = uint32(len(data))
+	p.Data = data
+	return p, nil
 }
 
 // Send sends packet p to the server.
 func (c *Client) Send(ctx context.Context, p Packet) error {
-	return c.conn.Send(ctx, p)
+	return c.currentConn().Send(ctx, p)
+}
+
+// handle is called by conn's reader for every incoming packet before it's passed to Recv.
+func (c *Client) handle(conn *Conn, p Packet) {
+	switch p.Msg {
+	case MsgCSLogout:
+		le, err := ParseLogout(p)
+		if err != nil {
+			c.logger.Printf("could not parse logout: %v\n", err)
+			return
+		}
+		c.logger.Printf("> received \"MRIM_CS_LOGOUT\" packet: %d, %04x, reason 0x%x\n", p.Seq, p.Msg, le.Reason)
+		// the server closes the connection after logout, fail it with the reason.
+		conn.fatal(le)
+
+	case MsgCSConnectionParams:
+		cp, err := ParseConnectionParams(p)
+		if err != nil {
+			c.logger.Printf("could not parse connection params: %v\n", err)
+			return
+		}
+		if cp.PingInterval > 0 {
+			conn.SetPingInterval(cp.PingInterval)
+		}
+
+	case MsgCSContactList2:
+		cl, err := ParseContactList(p)
+		if err != nil {
+			c.logger.Printf("could not parse contact list: %v\n", err)
+			return
+		}
+		c.roster.load(cl)
+
+	case MsgCSUserStatus:
+		us, err := ParseUserStatus(p)
+		if err != nil {
+			c.logger.Printf("could not parse user status: %v\n", err)
+			return
+		}
+		c.roster.updateStatus(us)
+	}
+}
+
+// Roster returns the user's contact list, which is kept up to date with the server.
+func (c *Client) Roster() *Roster {
+	return c.roster
+}
+
+// PingInterval returns the current interval between keepalive pings sent to the server.
+func (c *Client) PingInterval() time.Duration {
+	return c.currentConn().PingInterval()
+}
+
+// Dropped returns the number of incoming packets per message type,
+// which were dropped because the receive queue was full. Drops of the connections lost
+// before reconnection are counted too.
+func (c *Client) Dropped() map[uint32]uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	m := c.conn.Dropped()
+	for msg, n := range c.dropped {
+		m[msg] += n
+	}
+	return m
 }
 
 // Recv reads next packet from the server.
+// If reconnection is enabled, Recv waits for the client to reconnect, when the connection is lost.
 func (c *Client) Recv() (p Packet, err error) {
+	return c.RecvContext(context.Background())
+}
+
+// RecvContext is like Recv, but it returns when ctx is done.
+func (c *Client) RecvContext(ctx context.Context) (p Packet, err error) {
 	if !c.helloAck {
 		return p, ErrNoHello
 	}
-	return c.conn.Recv()
+	for {
+		c.mu.Lock()
+		conn, reconnected := c.conn, c.reconnected
+		c.mu.Unlock()
+
+		p, err = conn.RecvContext(ctx)
+		if err == nil {
+			break
+		}
+		if !c.reconnect || !canReconnect(err) {
+			return p, err
+		}
+		select {
+		case <-reconnected:
+		case <-c.done:
+			return p, err
+		case <-ctx.Done():
+			return p, ctx.Err()
+		}
+		c.mu.Lock()
+		stopErr := c.err
+		c.mu.Unlock()
+		if stopErr != nil {
+			return p, stopErr
+		}
+	}
+
+	if p.Msg == MsgCSMessageAck && c.autoAck {
+		c.ackPacket(ctx, p)
+	}
+
+	return p, nil
+}
+
+// ackPacket acknowledges the incoming message in p, when it's handed out to the user.
+// Acknowledging it earlier could confirm a message, which was dropped by the receive queue.
+func (c *Client) ackPacket(ctx context.Context, p Packet) {
+	m, err := ParseMessage(p)
+	if err != nil {
+		c.logger.Printf("could not parse message: %v\n", err)
+		return
+	}
+	if err := c.AckMessage(ctx, m); err != nil {
+		c.logger.Printf("could not acknowledge message %d: %v\n", m.ID, err)
+	}
 }
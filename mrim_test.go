@@ -0,0 +1,94 @@
+package mrim
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+// newTestClient returns a logged in client, connected to the server side of the pipe.
+func newTestClient(t *testing.T, queueSize int) (*Client, net.Conn) {
+	t.Helper()
+	client, server := net.Pipe()
+	c := &Client{
+		roster:      newRoster(),
+		typing:      make(map[string]time.Time),
+		logger:      defaultLogger,
+		autoAck:     true,
+		helloAck:    true,
+		reconnected: make(chan struct{}),
+		done:        make(chan struct{}),
+		dropped:     make(map[uint32]uint64),
+	}
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+	c.conn = NewConn(c.ctx, client)
+	c.conn.SetRecvQueue(queueSize, OverflowDropNewest)
+	c.runConn(c.conn)
+	return c, server
+}
+
+func testMessageBody(id uint32) []byte {
+	var w PacketWriter
+	w.WriteData(id)
+	w.WriteData(uint32(0))
+	w.WriteData("a@mail.ru")
+	w.WriteData(LPSA("hello"))
+	return w.Packet(MsgCSMessageAck).Data
+}
+
+func TestClientAcksOnlyReceivedMessages(t *testing.T) {
+	c, server := newTestClient(t, 1)
+	packets := serverReader(server)
+	defer server.Close()
+	defer c.Close()
+
+	writeTestPacket(t, server, 1, MsgCSMessageAck, testMessageBody(1))
+	writeTestPacket(t, server, 2, MsgCSMessageAck, testMessageBody(2))
+	waitFor(t, "dropped message", func() bool {
+		return c.Dropped()[MsgCSMessageAck] == 1
+	})
+
+	p, err := c.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Release()
+
+	var ack csMessageRecv
+	select {
+	case p := <-packets:
+		if p.Msg != MsgCSMessageRecv {
+			t.Fatalf("got packet 0x%x, want \"MRIM_CS_MESSAGE_RECV\"", p.Msg)
+		}
+		if err := Unmarshal(p.Data, &ack); err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for ack")
+	}
+	if ack.MsgID != 1 {
+		t.Errorf("acked message %d, want 1", ack.MsgID)
+	}
+
+	select {
+	case p := <-packets:
+		t.Errorf("unexpected packet 0x%x", p.Msg)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestClientDroppedKeepsLostConnections(t *testing.T) {
+	c, server := newTestClient(t, 1)
+	defer server.Close()
+	defer c.Close()
+
+	// drops of a connection lost before reconnection.
+	c.dropped[MsgCSMessageAck] = 2
+
+	writeTestPacket(t, server, 1, MsgCSMessageAck, testMessageBody(1))
+	writeTestPacket(t, server, 2, MsgCSMessageAck, testMessageBody(2))
+	waitFor(t, "dropped message", func() bool {
+		return c.Dropped()[MsgCSMessageAck] == 3
+	})
+}
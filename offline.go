@@ -0,0 +1,176 @@
+package mrim
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/narqo/mrim/internal/charset"
+)
+
+// UIDL is an unique identifier of the offline message.
+type UIDL [8]byte
+
+// OfflineMessage is a message, which was sent to the user while they were offline.
+// Offline messages are received with "MRIM_CS_OFFLINE_MESSAGE_ACK" after the login.
+type OfflineMessage struct {
+	UIDL    UIDL
+	From    string
+	Date    time.Time
+	Subject string
+	// Flags are the MessageFlag* bits from "X-MRIM-Flags" header.
+	Flags uint32
+	Text  string
+	// RTF is a packed rtf version of the message, if the message has one.
+	RTF []byte
+}
+
+// ParseOfflineMessage decodes "MRIM_CS_OFFLINE_MESSAGE_ACK" packet into an offline message.
+func ParseOfflineMessage(p Packet) (m OfflineMessage, err error) {
+	if p.Msg != MrimCSOfflineMessageAck {
+		return m, PacketError{p, errUnknownPacket}
+	}
+
+	r := NewPacketReader(p.Data)
+	copy(m.UIDL[:], r.ReadBytes(len(m.UIDL)))
+	raw := r.ReadLPS()
+	if err := r.Err(); err != nil {
+		return m, PacketError{p, fmt.Errorf("could not read message: %v", err)}
+	}
+	err = parseOfflineMessage([]byte(raw), &m)
+	if err != nil {
+		return m, PacketError{p, err}
+	}
+	return m, nil
+}
+
+// parseOfflineMessage parses RFC 822 formatted offline message.
+func parseOfflineMessage(raw []byte, m *OfflineMessage) error {
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return fmt.Errorf("could not read message: %v", err)
+	}
+
+	h := msg.Header
+	m.From = h.Get("From")
+	m.Subject = h.Get("Subject")
+	if date := h.Get("Date"); date != "" {
+		m.Date, err = mail.ParseDate(date)
+		if err != nil {
+			return fmt.Errorf("bad date %q: %v", date, err)
+		}
+	}
+	if flags := h.Get("X-MRIM-Flags"); flags != "" {
+		v, err := strconv.ParseUint(strings.TrimSpace(flags), 16, 32)
+		if err != nil {
+			return fmt.Errorf("bad flags %q: %v", flags, err)
+		}
+		m.Flags = uint32(v)
+	}
+
+	mediaType, params, err := parseContentType(h.Get("Content-Type"))
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(mediaType, "multipart/") {
+		body, err := readPart(msg.Body, h.Get("Content-Transfer-Encoding"))
+		if err != nil {
+			return err
+		}
+		m.Text, err = decodeText(body, params["charset"])
+		return err
+	}
+
+	mr := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("could not read multipart: %v", err)
+		}
+		mediaType, params, err := parseContentType(part.Header.Get("Content-Type"))
+		if err != nil {
+			return err
+		}
+		body, err := readPart(part, part.Header.Get("Content-Transfer-Encoding"))
+		if err != nil {
+			return err
+		}
+		switch mediaType {
+		case "text/plain":
+			m.Text, err = decodeText(body, params["charset"])
+			if err != nil {
+				return err
+			}
+		case "application/x-mrim-rtf":
+			m.RTF = bytes.TrimSpace(body)
+		}
+	}
+	return nil
+}
+
+func parseContentType(v string) (string, map[string]string, error) {
+	if v == "" {
+		return "text/plain", map[string]string{}, nil
+	}
+	mediaType, params, err := mime.ParseMediaType(v)
+	if err != nil {
+		return "", nil, fmt.Errorf("bad content type %q: %v", v, err)
+	}
+	return mediaType, params, nil
+}
+
+func readPart(r io.Reader, encoding string) ([]byte, error) {
+	body, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("could not read body: %v", err)
+	}
+	if strings.EqualFold(strings.TrimSpace(encoding), "base64") {
+		// decoder skips new lines, so the body is decoded as is.
+		body, err = base64.StdEncoding.DecodeString(string(body))
+		if err != nil {
+			return nil, fmt.Errorf("could not decode base64 body: %v", err)
+		}
+	}
+	return body, nil
+}
+
+// decodeText converts text in the named charset into a string.
+func decodeText(b []byte, name string) (string, error) {
+	switch strings.ToLower(name) {
+	case "utf-16le", "utf-16":
+		return charset.DecodeUTF16LE(b)
+	case "cp-1251", "cp1251", "windows-1251":
+		return charset.DecodeCP1251(b), nil
+	}
+	return string(b), nil
+}
+
+// DeleteOfflineMessage sends "MRIM_CS_DELETE_OFFLINE_MESSAGE", which removes the offline message from the server.
+// Server delivers offline messages on each login, until they are deleted.
+//
+// Offline messages are deleted automatically after they are returned by RecvEvent, unless Options.KeepOfflineMessages is set.
+// Messages, which couldn't be decoded, and messages returned by Recv are kept on the server.
+func (c *Client) DeleteOfflineMessage(ctx context.Context, uidl UIDL) error {
+	data, err := Marshal(csDeleteOfflineMessage{uidl[:]})
+	if err != nil {
+		return err
+	}
+	return c.currentConn().Do(ctx, MsgCSDeleteOfflineMessage, data)
+}
+
+// csDeleteOfflineMessage is the body of "MRIM_CS_DELETE_OFFLINE_MESSAGE".
+type csDeleteOfflineMessage struct {
+	UIDL []byte `mrim:"rest"`
+}
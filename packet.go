@@ -6,6 +6,8 @@ import (
 	"errors"
 	"fmt"
 	"io"
+
+	"github.com/narqo/mrim/internal/charset"
 )
 
 type PacketError struct {
@@ -26,9 +28,29 @@ type Header struct {
 	Len uint32
 }
 
+// Packet is a single message of the protocol.
+//
+// Packets returned by the reader own their data. The data's memory may be taken from a pool,
+// so high-throughput consumers should call Release once the packet isn't needed anymore.
 type Packet struct {
 	Header
 	Data []byte
+
+	// buf is the pooled buffer backing Data.
+	buf *packetBuf
+}
+
+// Release returns the packet's data to the pool. The packet's data must not be used after Release.
+// It's safe to call Release on packets, which weren't read from the connection.
+//
+// Copies of a packet share its buffer, which is returned to the pool by the first Release.
+// Releasing other copies only resets them, but their data must not be used either.
+func (p *Packet) Release() {
+	if p.buf != nil {
+		p.buf.release()
+		p.buf = nil
+	}
+	p.Data = nil
 }
 
 var headerReserved [16]byte // not used, must be filled with zeroes
@@ -91,7 +113,9 @@ func readPacketHeader(buf []byte, p *Packet) (err error) {
 	}
 
 	version := binary.LittleEndian.Uint32(buf[4:])
-	_ = version
+	if version>>16 != protoVersionMajor {
+		return fmt.Errorf("unsupported version: %d.%d", version>>16, version&0xffff)
+	}
 
 	p.Seq = binary.LittleEndian.Uint32(buf[8:])
 	p.Msg = binary.LittleEndian.Uint32(buf[12:])
@@ -102,6 +126,14 @@ func readPacketHeader(buf []byte, p *Packet) (err error) {
 	return nil
 }
 
+// LPSA is a string, which is written as CP1251 encoded LPS.
+// Most of the legacy text fields of the protocol are ANSI strings.
+type LPSA string
+
+// LPSW is a string, which is written as UTF-16LE encoded LPS.
+// It's used for unicode fields, e.g. nicknames and status texts.
+type LPSW string
+
 type PacketWriter struct {
 	b bytes.Buffer
 }
@@ -110,7 +142,8 @@ func (w *PacketWriter) Write(p []byte) (int, error) {
 	return w.b.Write(p)
 }
 
-// maybe https://github.com/go-redis/redis/blob/master/internal/proto/scan.go
+// WriteData writes v as UL, if it's an integer, or as LPS, if it's a string or []byte.
+// Use Marshal to encode whole structs.
 func (w *PacketWriter) WriteData(v interface{}) (n int, err error) {
 	if v == nil {
 		return
@@ -137,6 +170,10 @@ func (w *PacketWriter) WriteData(v interface{}) (n int, err error) {
 		}
 		n, err = w.Write([]byte(v))
 		n += 4
+	case LPSA:
+		return w.WriteData(charset.EncodeCP1251(string(v)))
+	case LPSW:
+		return w.WriteData(charset.EncodeUTF16LE(string(v)))
 	default:
 		err = fmt.Errorf("unsupported type %T", v)
 	}
@@ -150,15 +187,93 @@ func (w *PacketWriter) Packet(msg uint32) (p Packet) {
 	return
 }
 
-// unpackLPS unpacks LSP (long pascal string, size uint32 + str string) from v.
-func unpackLPS(v []byte) (string, error) {
+// ErrShortPacket is returned by PacketReader when the packet's data ends before the value being read.
+var ErrShortPacket = errors.New("mrim: packet data too short")
+
+// PacketReader decodes values from the packet's data.
+// Errors are sticky: once a read failed, all subsequent reads return zero values,
+// and the error is returned by Err.
+type PacketReader struct {
+	data []byte
+	err  error
+}
+
+// NewPacketReader returns a reader over data.
+func NewPacketReader(data []byte) *PacketReader {
+	return &PacketReader{data: data}
+}
+
+// Err returns the first error occurred while reading.
+func (r *PacketReader) Err() error {
+	return r.err
+}
+
+// Remaining returns the number of unread bytes.
+func (r *PacketReader) Remaining() int {
+	return len(r.data)
+}
+
+// ReadBytes reads next n bytes. The returned slice shares the memory with the reader's data.
+func (r *PacketReader) ReadBytes(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || n > len(r.data) {
+		r.err = ErrShortPacket
+		return nil
+	}
+	v := r.data[:n:n]
+	r.data = r.data[n:]
+	return v
+}
+
+// ReadUL reads UL (uint32).
+func (r *PacketReader) ReadUL() uint32 {
+	v := r.ReadBytes(4)
 	if v == nil {
-		return "", nil
+		return 0
 	}
-	l := binary.LittleEndian.Uint32(v)
-	v = v[4:]
-	if int(l) > len(v) {
-		return "", errors.New("out of bound")
+	return binary.LittleEndian.Uint32(v)
+}
+
+// ReadLPS reads LPS (long pascal string, size uint32 + str string) as is.
+func (r *PacketReader) ReadLPS() string {
+	n := r.ReadUL()
+	if r.err != nil {
+		return ""
 	}
-	return string(v[:l]), nil
+	if uint64(n) > uint64(len(r.data)) {
+		r.err = ErrShortPacket
+		return ""
+	}
+	return string(r.ReadBytes(int(n)))
+}
+
+// ReadLPSA reads CP1251 encoded LPS.
+func (r *PacketReader) ReadLPSA() string {
+	return decodeLPSA(r.ReadLPS())
+}
+
+// ReadLPSW reads UTF-16LE encoded LPS.
+func (r *PacketReader) ReadLPSW() string {
+	v := r.ReadLPS()
+	if r.err != nil {
+		return ""
+	}
+	s, err := decodeLPSW(v)
+	if err != nil {
+		r.err = err
+		return ""
+	}
+	return s
+}
+
+// decodeLPSA converts raw value of CP1251 encoded LPS into a string.
+func decodeLPSA(s string) string {
+	return charset.DecodeCP1251([]byte(s))
+}
+
+// decodeLPSW converts raw value of UTF-16LE encoded LPS into a string.
+func decodeLPSW(s string) (string, error) {
+	return charset.DecodeUTF16LE([]byte(s))
 }
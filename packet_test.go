@@ -0,0 +1,38 @@
+package mrim
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+)
+
+func TestPacketReleaseCopies(t *testing.T) {
+	body := testBody(2)
+	var buf bytes.Buffer
+	err := writePacket(&buf, Packet{
+		Header: Header{Seq: 1, Msg: MsgCSUserInfo, Len: uint32(len(body))},
+		Data:   body,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := Reader{br: bufio.NewReader(&buf)}
+	p, err := r.ReadPacket()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q := p
+	p.Release()
+	q.Release()
+	p.Release()
+	if q.Data != nil || q.buf != nil {
+		t.Errorf("released copy keeps its data")
+	}
+
+	// the buffer must be put to the pool once, otherwise two buffers taken next would be the same.
+	b1, b2 := getBuf(len(body)), getBuf(len(body))
+	if &(*b1)[:1][0] == &(*b2)[:1][0] {
+		t.Errorf("buffer was put to the pool twice")
+	}
+}
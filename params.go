@@ -0,0 +1,31 @@
+package mrim
+
+import (
+	"time"
+)
+
+// ConnectionParams are parameters of the connection sent by the server with "MRIM_CS_CONNECTION_PARAMS".
+type ConnectionParams struct {
+	// PingInterval is the interval between keepalive pings expected by the server.
+	PingInterval time.Duration
+}
+
+// ParseConnectionParams decodes "MRIM_CS_CONNECTION_PARAMS" packet.
+func ParseConnectionParams(p Packet) (cp ConnectionParams, err error) {
+	if p.Msg != MsgCSConnectionParams {
+		return cp, PacketError{p, errUnknownPacket}
+	}
+
+	var body csConnectionParams
+	if err := Unmarshal(p.Data, &body); err != nil {
+		return cp, PacketError{p, err}
+	}
+	cp.PingInterval = time.Duration(body.PingPeriod) * time.Second
+	return cp, nil
+}
+
+// csConnectionParams is the body of "MRIM_CS_CONNECTION_PARAMS".
+// Fields sent by newer servers after the ping period are ignored.
+type csConnectionParams struct {
+	PingPeriod uint32 `mrim:"ul"`
+}
@@ -0,0 +1,64 @@
+package mrim
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// Size classes of pooled packet buffers, from 1<<minPoolShift to 1<<maxPoolShift bytes.
+// Bodies, which are larger than the biggest class, are allocated directly.
+const (
+	minPoolShift = 6
+	maxPoolShift = 16
+)
+
+var bufPools [maxPoolShift - minPoolShift + 1]sync.Pool
+
+// poolIndex returns the index of the smallest size class, which fits n bytes.
+func poolIndex(n int) int {
+	for i := 0; i < len(bufPools); i++ {
+		if n <= 1<<(minPoolShift+uint(i)) {
+			return i
+		}
+	}
+	return -1
+}
+
+// getBuf returns a buffer of length n. It's taken from the pool, if the pool has a fitting size class.
+func getBuf(n int) *[]byte {
+	i := poolIndex(n)
+	if i < 0 {
+		b := make([]byte, n)
+		return &b
+	}
+	if v := bufPools[i].Get(); v != nil {
+		b := v.(*[]byte)
+		*b = (*b)[:n]
+		return b
+	}
+	b := make([]byte, n, 1<<(minPoolShift+uint(i)))
+	return &b
+}
+
+// putBuf returns the buffer to the pool.
+func putBuf(b *[]byte) {
+	i := poolIndex(cap(*b))
+	if i < 0 || cap(*b) != 1<<(minPoolShift+uint(i)) {
+		return
+	}
+	*b = (*b)[:0]
+	bufPools[i].Put(b)
+}
+
+// packetBuf is a pooled buffer shared by the copies of a packet.
+type packetBuf struct {
+	b        *[]byte
+	released int32
+}
+
+// release returns the buffer to the pool. Only the first call puts it back.
+func (pb *packetBuf) release() {
+	if atomic.CompareAndSwapInt32(&pb.released, 0, 1) {
+		putBuf(pb.b)
+	}
+}
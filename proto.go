@@ -30,6 +30,9 @@ const (
 	MsgCSModifyContactAck     = 0x101C
 	MrimCSOfflineMessageAck   = 0x101D
 	MsgCSDeleteOfflineMessage = 0x101E
+	MsgCSAuthorize            = 0x1020
+	MsgCSAuthorizeAck         = 0x1021
+	MsgCSChangeStatus         = 0x1022
 	MsgCSGetMpopSession       = 0x1024
 	MsgCSMpopSession          = 0x1025
 	MsgCSAnketaInfo           = 0x1028
@@ -57,6 +60,7 @@ const (
 	StatusOnline         = 0x00000001
 	StatusAway           = 0x00000002
 	StatusUndeterminated = 0x00000003
+	StatusUserDefined    = 0x00000004
 	StatusFlagInvisible  = 0x80000000
 )
 
@@ -64,8 +68,27 @@ const (
 	MessageFlagOffline   = 0x00000001
 	MessageFlagNorecv    = 0x00000004
 	MessageFlagAuthorize = 0x00000008
+	MessageFlagRTF       = 0x00000080
+	MessageFlagNotify    = 0x00000400
+	MessageFlagAlarm     = 0x00004000
+	MessageFlagV1p16     = 0x00100000
+	MessageFlagCP1251    = 0x00200000
 )
 
+const (
+	ContactFlagRemoved     = 0x00000001
+	ContactFlagGroup       = 0x00000002
+	ContactFlagInvisible   = 0x00000004
+	ContactFlagVisible     = 0x00000008
+	ContactFlagIgnore      = 0x00000010
+	ContactFlagShadow      = 0x00000020
+	ContactFlagAuthorized  = 0x00000040
+	ContactFlagUnicodeName = 0x00000200
+)
+
+// LogoutFlagNoRelogin is set in the reason of "MRIM_CS_LOGOUT", if the client must not log in again.
+const LogoutFlagNoRelogin = 0x00000010
+
 const (
 	mrimCSWPRequestParamUser      uint = iota
 	mrimCSWPRequestParamDomain
@@ -75,3 +98,6 @@ const (
 	mrimCSWPRequestParamSex
 	mrimCSWPRequestParamBirthday
 )
+
+// MaxGroups is the maximum number of groups in the contact list.
+const MaxGroups = 20
@@ -0,0 +1,115 @@
+package mrim
+
+import (
+	"errors"
+	"sync"
+)
+
+// OverflowPolicy defines what happens with incoming packets, when the receive queue is full.
+type OverflowPolicy int
+
+const (
+	// OverflowDropNewest drops the incoming packet. It's the default policy.
+	OverflowDropNewest OverflowPolicy = iota
+	// OverflowDropOldest drops the oldest queued packet to make room for the new one.
+	OverflowDropOldest
+	// OverflowBlock blocks the connection's reader until the consumer calls Recv.
+	// Replies to Conn.Call are read by the same reader, so requests, which wait for the reply,
+	// e.g. Client.SendMessage, hang while the queue is full. Use it only if Recv is called constantly.
+	OverflowBlock
+	// OverflowDisconnect fails the connection with ErrRecvOverflow.
+	OverflowDisconnect
+)
+
+// DefaultRecvQueueSize is the default number of packets queued for Recv.
+const DefaultRecvQueueSize = 1400
+
+// ErrRecvOverflow is the error of the connection, which was closed because the receive queue overflowed.
+var ErrRecvOverflow = errors.New("mrim: receive queue overflow")
+
+// recvQueue is a bounded queue of received packets waiting for Recv.
+type recvQueue struct {
+	c      chan Packet
+	policy OverflowPolicy
+	// done unblocks the writer of the queue with OverflowBlock policy.
+	done <-chan struct{}
+
+	mu      sync.Mutex
+	dropped map[uint32]uint64
+}
+
+func newRecvQueue(size int, policy OverflowPolicy, done <-chan struct{}) *recvQueue {
+	if size <= 0 {
+		size = DefaultRecvQueueSize
+	}
+	return &recvQueue{
+		c:       make(chan Packet, size),
+		policy:  policy,
+		done:    done,
+		dropped: make(map[uint32]uint64),
+	}
+}
+
+// put queues the packet according to the overflow policy.
+// It returns an error if the packet couldn't be queued and the connection must be closed.
+func (q *recvQueue) put(p Packet) error {
+	select {
+	case q.c <- p:
+		return nil
+	default:
+	}
+
+	switch q.policy {
+	case OverflowBlock:
+		select {
+		case q.c <- p:
+		case <-q.done:
+			p.Release()
+		}
+
+	case OverflowDropOldest:
+		for {
+			select {
+			case q.c <- p:
+				return nil
+			default:
+			}
+			select {
+			case old := <-q.c:
+				q.drop(old)
+			default:
+			}
+		}
+
+	case OverflowDropNewest:
+		q.drop(p)
+
+	case OverflowDisconnect:
+		q.drop(p)
+		return ErrRecvOverflow
+	}
+	return nil
+}
+
+func (q *recvQueue) take() <-chan Packet {
+	return q.c
+}
+
+func (q *recvQueue) drop(p Packet) {
+	debugf("drop packet: %04x", p.Msg)
+	q.mu.Lock()
+	q.dropped[p.Msg]++
+	q.mu.Unlock()
+	p.Release()
+}
+
+// droppedCount returns the number of dropped packets per message type.
+func (q *recvQueue) droppedCount() map[uint32]uint64 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	m := make(map[uint32]uint64, len(q.dropped))
+	for msg, n := range q.dropped {
+		m[msg] = n
+	}
+	return m
+}
@@ -0,0 +1,207 @@
+package mrim
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+)
+
+const (
+	DefaultReconnectMinDelay = time.Second
+	DefaultReconnectMaxDelay = 5 * time.Minute
+)
+
+// ConnState is a state of the client's connection to the server.
+type ConnState int
+
+const (
+	// StateConnected is reported when the session was established or restored.
+	StateConnected ConnState = iota
+	// StateDisconnected is reported when the connection was lost.
+	StateDisconnected
+	// StateReconnecting is reported before every reconnection attempt.
+	StateReconnecting
+)
+
+func (s ConnState) String() string {
+	switch s {
+	case StateConnected:
+		return "connected"
+	case StateDisconnected:
+		return "disconnected"
+	case StateReconnecting:
+		return "reconnecting"
+	}
+	return "unknown"
+}
+
+// ConnStateEvent describes a change of the connection's state.
+type ConnStateEvent struct {
+	State ConnState
+	// Err is the error, which caused the disconnection or the failure of the previous attempt.
+	Err error
+	// Attempt is the number of the reconnection attempt, starting from 1.
+	Attempt int
+}
+
+func (c *Client) notifyConnState(state ConnState, err error, attempt int) {
+	if c.onConnState == nil {
+		return
+	}
+	c.onConnState(ConnStateEvent{
+		State:   state,
+		Err:     err,
+		Attempt: attempt,
+	})
+}
+
+// supervise waits for the connection to be lost and reconnects the client,
+// until the client is closed or its context is done.
+func (c *Client) supervise(conn *Conn) {
+	defer c.wg.Done()
+
+	for {
+		select {
+		case <-conn.Done():
+		case <-c.done:
+			return
+		}
+
+		err := conn.Err()
+		if err == nil {
+			// the connection was closed by the user.
+			err = ErrClosed
+		}
+		c.notifyConnState(StateDisconnected, err, 0)
+
+		if !canReconnect(err) {
+			c.stop(err)
+			return
+		}
+
+		conn = c.reconnectLoop(err)
+		if conn == nil {
+			return
+		}
+	}
+}
+
+// canReconnect reports whether the session may be restored after the connection failed with err.
+// The client doesn't log in again if the server forbids it, otherwise two clients
+// sharing the account would kick each other forever. Neither it retries rejected credentials,
+// nor packets exceeding the reader's limit, which the server would deliver again after the login.
+func canReconnect(err error) bool {
+	if err == context.Canceled || err == context.DeadlineExceeded || err == ErrClosed {
+		return false
+	}
+	if errors.Is(err, ErrPacketTooLarge) {
+		return false
+	}
+	var le LogoutError
+	if errors.As(err, &le) && le.NoRelogin {
+		return false
+	}
+	var ae AuthError
+	if errors.As(err, &ae) {
+		return false
+	}
+	return true
+}
+
+// stop makes the client give up reconnecting with err.
+func (c *Client) stop(err error) {
+	c.mu.Lock()
+	if c.err == nil {
+		c.err = err
+		close(c.reconnected)
+	}
+	c.mu.Unlock()
+}
+
+// reconnectLoop restores the session with exponential backoff.
+// It returns nil if the client was closed or must not reconnect.
+func (c *Client) reconnectLoop(err error) *Conn {
+	for attempt := 1; ; attempt++ {
+		t := time.NewTimer(c.backoff(attempt))
+		select {
+		case <-t.C:
+		case <-c.done:
+			t.Stop()
+			return nil
+		case <-c.ctx.Done():
+			t.Stop()
+			return nil
+		}
+
+		c.notifyConnState(StateReconnecting, err, attempt)
+
+		var conn *Conn
+		conn, err = c.restore(c.ctx)
+		if err != nil {
+			c.logger.Printf("could not reconnect: %v\n", err)
+			if !canReconnect(err) {
+				c.notifyConnState(StateDisconnected, err, attempt)
+				c.stop(err)
+				return nil
+			}
+			continue
+		}
+
+		c.notifyConnState(StateConnected, nil, attempt)
+		return conn
+	}
+}
+
+// backoff returns the delay before the reconnection attempt.
+// The delay grows exponentially and is randomized by up to a half.
+func (c *Client) backoff(attempt int) time.Duration {
+	d := c.reconnectMinDelay
+	for i := 1; i < attempt && d < c.reconnectMaxDelay; i++ {
+		d *= 2
+	}
+	if d > c.reconnectMaxDelay {
+		d = c.reconnectMaxDelay
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// restore establishes a new session with the last status set and replaces the lost connection.
+// The server sends a fresh contact list after the login, which reloads the roster.
+func (c *Client) restore(ctx context.Context) (*Conn, error) {
+	conn, loginAddr, err := c.dialLogin(ctx, c.addr)
+	if err != nil {
+		return nil, err
+	}
+	err = c.hello(ctx, conn)
+	if err == nil {
+		err = c.login(ctx, conn, c.username, c.password, c.Status())
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	c.mu.Lock()
+	select {
+	case <-c.done:
+		c.mu.Unlock()
+		conn.Close()
+		return nil, ErrClosed
+	default:
+	}
+	// keep the drops of the lost connection, so Dropped counts them over the client's lifetime.
+	for msg, n := range c.conn.Dropped() {
+		c.dropped[msg] += n
+	}
+	c.conn = conn
+	c.loginAddr = loginAddr
+	reconnected := c.reconnected
+	c.reconnected = make(chan struct{})
+	c.mu.Unlock()
+
+	c.runConn(conn)
+	close(reconnected)
+
+	return conn, nil
+}
@@ -0,0 +1,32 @@
+package mrim
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+)
+
+func TestCanReconnect(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{io.EOF, true},
+		{ErrTimeout, true},
+		{LogoutError{}, true},
+		{LogoutError{NoRelogin: true}, false},
+		{AuthError{}, false},
+		{ErrClosed, false},
+		{context.Canceled, false},
+		{ErrPacketTooLarge, false},
+		{fmt.Errorf("read: %w", ErrPacketTooLarge), false},
+		{errors.New("connection reset"), true},
+	}
+	for _, tt := range tests {
+		if got := canReconnect(tt.err); got != tt.want {
+			t.Errorf("%v: got %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
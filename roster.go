@@ -0,0 +1,280 @@
+package mrim
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// UserStatus is a contact's presence update received with "MRIM_CS_USER_STATUS".
+type UserStatus struct {
+	Email        string
+	Status       uint32
+	XStatusURI   string
+	XStatusTitle string
+	XStatusDesc  string
+	Features     uint32
+	UserAgent    string
+}
+
+// ParseUserStatus decodes "MRIM_CS_USER_STATUS" packet.
+func ParseUserStatus(p Packet) (us UserStatus, err error) {
+	if p.Msg != MsgCSUserStatus {
+		return us, PacketError{p, errUnknownPacket}
+	}
+
+	r := NewPacketReader(p.Data)
+	us.Status = r.ReadUL()
+	s := r.ReadLPS()
+	if r.Err() == nil && r.Remaining() == 0 {
+		// before 1.14 the packet consisted of status and user only.
+		us.Email = s
+		return us, nil
+	}
+	us.XStatusURI = s
+	us.XStatusTitle = r.ReadLPSW()
+	us.XStatusDesc = r.ReadLPSW()
+	us.Email = r.ReadLPS()
+	us.Features = r.ReadUL()
+	us.UserAgent = r.ReadLPS()
+	if err := r.Err(); err != nil {
+		return us, PacketError{p, fmt.Errorf("could not read user status: %v", err)}
+	}
+	return us, nil
+}
+
+// RosterEventType is a kind of change of the roster.
+type RosterEventType int
+
+const (
+	// RosterLoaded is sent when the whole contact list was received from the server.
+	RosterLoaded RosterEventType = iota
+	ContactAdded
+	ContactModified
+	ContactRemoved
+	// ContactStatusChanged is sent when the contact's presence was updated.
+	ContactStatusChanged
+	GroupAdded
+	GroupModified
+	GroupRemoved
+)
+
+// RosterEvent describes a change of the roster.
+type RosterEvent struct {
+	Type RosterEventType
+	// Contact is a state of the contact after the change. It's set for Contact* events.
+	Contact Contact
+	// Group is a state of the group after the change. It's set for Group* events.
+	Group Group
+}
+
+// Roster is an in-memory contact list of the user, which is kept in sync with the server.
+// It's safe to use Roster from multiple goroutines.
+type Roster struct {
+	mu       sync.RWMutex
+	groups   []Group
+	contacts []Contact
+	// index of contacts by lower-cased email.
+	index map[string]int
+
+	subMu   sync.Mutex
+	subs    map[int]func(RosterEvent)
+	nextSub int
+}
+
+func newRoster() *Roster {
+	return &Roster{
+		index: make(map[string]int),
+		subs:  make(map[int]func(RosterEvent)),
+	}
+}
+
+// Get returns the contact with the given email.
+func (r *Roster) Get(email string) (ct Contact, ok bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	i, ok := r.index[strings.ToLower(email)]
+	if !ok {
+		return ct, false
+	}
+	return r.contacts[i], true
+}
+
+// Contacts returns all contacts of the roster.
+func (r *Roster) Contacts() []Contact {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return append([]Contact(nil), r.contacts...)
+}
+
+// Groups returns all groups of the roster.
+func (r *Roster) Groups() []Group {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return append([]Group(nil), r.groups...)
+}
+
+// Online returns contacts, which aren't offline.
+func (r *Roster) Online() []Contact {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var online []Contact
+	for _, ct := range r.contacts {
+		if ct.Status&^StatusFlagInvisible != StatusOffline {
+			online = append(online, ct)
+		}
+	}
+	return online
+}
+
+// Subscribe registers fn to be called on every change of the roster.
+// fn is called from the connection's reader, so it must not block.
+// The returned function cancels the subscription.
+func (r *Roster) Subscribe(fn func(RosterEvent)) (cancel func()) {
+	r.subMu.Lock()
+	id := r.nextSub
+	r.nextSub++
+	r.subs[id] = fn
+	r.subMu.Unlock()
+
+	return func() {
+		r.subMu.Lock()
+		delete(r.subs, id)
+		r.subMu.Unlock()
+	}
+}
+
+func (r *Roster) notify(ev RosterEvent) {
+	r.subMu.Lock()
+	subs := make([]func(RosterEvent), 0, len(r.subs))
+	for _, fn := range r.subs {
+		subs = append(subs, fn)
+	}
+	r.subMu.Unlock()
+
+	for _, fn := range subs {
+		fn(ev)
+	}
+}
+
+// load replaces the content of the roster with the contact list.
+func (r *Roster) load(cl ContactList) {
+	r.mu.Lock()
+	r.groups = append([]Group(nil), cl.Groups...)
+	r.contacts = append([]Contact(nil), cl.Contacts...)
+	r.reindex()
+	r.mu.Unlock()
+
+	r.notify(RosterEvent{Type: RosterLoaded})
+}
+
+// reindex rebuilds the index of contacts. It must be called with r.mu held.
+func (r *Roster) reindex() {
+	r.index = make(map[string]int, len(r.contacts))
+	for i, ct := range r.contacts {
+		r.index[strings.ToLower(ct.Email)] = i
+	}
+}
+
+// put adds the contact to the roster or replaces the existing contact with the same email.
+func (r *Roster) put(ct Contact) {
+	typ := ContactAdded
+
+	r.mu.Lock()
+	email := strings.ToLower(ct.Email)
+	if i, ok := r.index[email]; ok {
+		r.contacts[i] = ct
+		typ = ContactModified
+	} else {
+		r.contacts = append(r.contacts, ct)
+		r.index[email] = len(r.contacts) - 1
+	}
+	r.mu.Unlock()
+
+	r.notify(RosterEvent{Type: typ, Contact: ct})
+}
+
+// remove removes the contact with the given email from the roster.
+func (r *Roster) remove(email string) {
+	r.mu.Lock()
+	i, ok := r.index[strings.ToLower(email)]
+	if !ok {
+		r.mu.Unlock()
+		return
+	}
+	ct := r.contacts[i]
+	r.contacts = append(r.contacts[:i], r.contacts[i+1:]...)
+	r.reindex()
+	r.mu.Unlock()
+
+	r.notify(RosterEvent{Type: ContactRemoved, Contact: ct})
+}
+
+// updateStatus applies the presence update to the contact.
+func (r *Roster) updateStatus(us UserStatus) {
+	r.mu.Lock()
+	i, ok := r.index[strings.ToLower(us.Email)]
+	if !ok {
+		r.mu.Unlock()
+		return
+	}
+	ct := &r.contacts[i]
+	ct.Status = us.Status
+	ct.XStatusURI = us.XStatusURI
+	ct.XStatusTitle = us.XStatusTitle
+	ct.XStatusDesc = us.XStatusDesc
+	ct.Features = us.Features
+	ct.UserAgent = us.UserAgent
+	updated := *ct
+	r.mu.Unlock()
+
+	r.notify(RosterEvent{Type: ContactStatusChanged, Contact: updated})
+}
+
+// Group returns the group with the given id.
+func (r *Roster) Group(id int) (g Group, ok bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, g := range r.groups {
+		if g.ID == id {
+			return g, true
+		}
+	}
+	return g, false
+}
+
+// putGroup adds the group to the roster or replaces the existing group with the same id.
+func (r *Roster) putGroup(g Group) {
+	typ := GroupAdded
+
+	r.mu.Lock()
+	i := 0
+	for ; i < len(r.groups); i++ {
+		if r.groups[i].ID == g.ID {
+			break
+		}
+	}
+	if i < len(r.groups) {
+		r.groups[i] = g
+		typ = GroupModified
+	} else {
+		r.groups = append(r.groups, g)
+	}
+	r.mu.Unlock()
+
+	r.notify(RosterEvent{Type: typ, Group: g})
+}
+
+// removeGroup removes the group with the given id from the roster.
+func (r *Roster) removeGroup(id int) {
+	r.mu.Lock()
+	for i, g := range r.groups {
+		if g.ID == id {
+			r.groups = append(r.groups[:i], r.groups[i+1:]...)
+			r.mu.Unlock()
+			r.notify(RosterEvent{Type: GroupRemoved, Group: g})
+			return
+		}
+	}
+	r.mu.Unlock()
+}
@@ -0,0 +1,284 @@
+package rtf
+
+import (
+	"strconv"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/narqo/mrim/internal/charset"
+)
+
+// destination is a kind of the rtf group the parser is in.
+type destination int
+
+const (
+	destText destination = iota
+	destFontTable
+	destColorTable
+	destSkip
+)
+
+// skipped are the destinations, which don't contribute to the plain text.
+var skipped = map[string]bool{
+	"stylesheet": true,
+	"info":       true,
+	"pict":       true,
+	"header":     true,
+	"footer":     true,
+	"object":     true,
+	"fldinst":    true,
+}
+
+type state struct {
+	dest destination
+	// uc is the number of fallback characters, which follow an unicode character.
+	uc int
+}
+
+type parser struct {
+	s   string
+	i   int
+	st  state
+	stk []state
+
+	text strings.Builder
+	// high surrogate waiting for its pair.
+	high rune
+	// pending number of fallback characters to skip.
+	skip int
+
+	fonts    map[int]string
+	font     int
+	fontName strings.Builder
+	fontIdx  int
+
+	colors     []uint32
+	colorIdx   int
+	colorEntry uint32
+}
+
+// parse extracts the plain text, the font and the color of the text from rtf document.
+func parse(doc string) (text, font string, color uint32) {
+	p := &parser{
+		s:        doc,
+		st:       state{uc: 1},
+		fonts:    make(map[int]string),
+		font:     -1,
+		fontIdx:  -1,
+		colorIdx: -1,
+	}
+	p.run()
+
+	text = strings.TrimRight(p.text.String(), "\r\n")
+	idx := p.fontIdx
+	if idx < 0 {
+		idx = 0
+	}
+	font = p.fonts[idx]
+	if p.colorIdx > 0 && p.colorIdx < len(p.colors) {
+		color = p.colors[p.colorIdx]
+	}
+	return text, font, color
+}
+
+func (p *parser) run() {
+	for p.i < len(p.s) {
+		c := p.s[p.i]
+		switch c {
+		case '{':
+			p.stk = append(p.stk, p.st)
+			p.i++
+		case '}':
+			if p.st.dest == destFontTable {
+				p.endFont()
+			}
+			if n := len(p.stk); n > 0 {
+				p.st = p.stk[n-1]
+				p.stk = p.stk[:n-1]
+			}
+			p.i++
+		case '\\':
+			p.i++
+			p.control()
+		case '\r', '\n':
+			p.i++
+		default:
+			p.i++
+			p.char(decodeByte(c))
+		}
+	}
+}
+
+// control parses control word or control symbol after the backslash.
+func (p *parser) control() {
+	if p.i >= len(p.s) {
+		return
+	}
+	c := p.s[p.i]
+	if !isLetter(c) {
+		p.i++
+		switch c {
+		case '\\', '{', '}':
+			p.char(rune(c))
+		case '\'':
+			if p.i+2 > len(p.s) {
+				return
+			}
+			b, err := strconv.ParseUint(p.s[p.i:p.i+2], 16, 8)
+			p.i += 2
+			if err == nil {
+				p.char(decodeByte(byte(b)))
+			}
+		case '*':
+			p.st.dest = destSkip
+		case '~':
+			p.char(' ')
+		case '_':
+			p.char('-')
+		case '\n', '\r':
+			p.char('\n')
+		}
+		return
+	}
+
+	start := p.i
+	for p.i < len(p.s) && isLetter(p.s[p.i]) {
+		p.i++
+	}
+	word := p.s[start:p.i]
+
+	param, hasParam := 0, false
+	start = p.i
+	if p.i < len(p.s) && p.s[p.i] == '-' {
+		p.i++
+	}
+	for p.i < len(p.s) && p.s[p.i] >= '0' && p.s[p.i] <= '9' {
+		p.i++
+	}
+	if p.i > start {
+		param, _ = strconv.Atoi(p.s[start:p.i])
+		hasParam = true
+	}
+	// a space delimiting the control word is a part of it.
+	if p.i < len(p.s) && p.s[p.i] == ' ' {
+		p.i++
+	}
+
+	p.word(word, param, hasParam)
+}
+
+func (p *parser) word(word string, param int, hasParam bool) {
+	switch word {
+	case "fonttbl":
+		p.st.dest = destFontTable
+		return
+	case "colortbl":
+		p.st.dest = destColorTable
+		return
+	case "uc":
+		p.st.uc = param
+		return
+	}
+	if skipped[word] {
+		p.st.dest = destSkip
+		return
+	}
+
+	switch p.st.dest {
+	case destFontTable:
+		if word == "f" {
+			p.endFont()
+			p.font = param
+		}
+
+	case destColorTable:
+		switch word {
+		case "red":
+			p.colorEntry |= uint32(param & 0xff)
+		case "green":
+			p.colorEntry |= uint32(param&0xff) << 8
+		case "blue":
+			p.colorEntry |= uint32(param&0xff) << 16
+		}
+
+	case destText:
+		switch word {
+		case "par", "line":
+			p.char('\n')
+		case "tab":
+			p.char('\t')
+		case "u":
+			if !hasParam {
+				return
+			}
+			if param < 0 {
+				param += 0x10000
+			}
+			p.skip = 0
+			p.char(rune(param))
+			p.skip = p.st.uc
+		case "f":
+			if p.fontIdx < 0 {
+				p.fontIdx = param
+			}
+		case "cf":
+			if p.colorIdx < 0 {
+				p.colorIdx = param
+			}
+		}
+	}
+}
+
+// char handles a character of the current destination.
+func (p *parser) char(r rune) {
+	switch p.st.dest {
+	case destFontTable:
+		if r == ';' {
+			p.endFont()
+		} else if p.font >= 0 {
+			p.fontName.WriteRune(r)
+		}
+
+	case destColorTable:
+		if r == ';' {
+			p.colors = append(p.colors, p.colorEntry)
+			p.colorEntry = 0
+		}
+
+	case destText:
+		if p.skip > 0 {
+			p.skip--
+			return
+		}
+		p.skip = 0
+		if utf16.IsSurrogate(r) {
+			if r < 0xDC00 {
+				p.high = r
+				return
+			}
+			r = utf16.DecodeRune(p.high, r)
+		}
+		p.high = 0
+		p.text.WriteRune(r)
+	}
+}
+
+func (p *parser) endFont() {
+	if p.font >= 0 {
+		if _, ok := p.fonts[p.font]; !ok {
+			p.fonts[p.font] = strings.TrimSpace(p.fontName.String())
+		}
+	}
+	p.font = -1
+	p.fontName.Reset()
+}
+
+// decodeByte decodes a non-ascii character of the document.
+// Documents produced by the clients are in CP1251 (\ansicpg1251).
+func decodeByte(b byte) rune {
+	return charset.DecodeCP1251Byte(b)
+}
+
+func isLetter(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
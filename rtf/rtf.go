@@ -0,0 +1,184 @@
+// Package rtf implements packing and unpacking of rtf messages used by Mail.Ru Agent.
+//
+// A packed rtf message is a base64 encoded zlib stream of the following data:
+//
+//	UL  count of the fields, always 2
+//	LPS rtf document
+//	LPS background color, UL in the 0x00BBGGRR form
+package rtf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+// Default formatting of messages.
+const (
+	DefaultFont    = "Tahoma"
+	DefaultColor   = 0x00000000
+	DefaultBgColor = 0x00FFFFFF
+)
+
+// MaxSize is the maximum size of an unpacked rtf message.
+// Real messages are a few kilobytes, larger ones are rejected by Decode.
+const MaxSize = 1 << 20
+
+// ErrTooLarge is returned by Decode, when the unpacked message exceeds MaxSize.
+var ErrTooLarge = errors.New("rtf: message too large")
+
+// Format is a formatting of the message.
+// Colors are in the 0x00BBGGRR form.
+type Format struct {
+	Font    string
+	Color   uint32
+	BgColor uint32
+}
+
+// Document is an unpacked rtf message.
+type Document struct {
+	Format
+	// Text is a plain text of the document.
+	Text string
+	// RTF is the source rtf document.
+	RTF string
+}
+
+// Encode formats text into rtf document and packs it.
+func Encode(text, font string, color, bgcolor uint32) ([]byte, error) {
+	if font == "" {
+		font = DefaultFont
+	}
+	doc := render(text, font, color)
+
+	var raw bytes.Buffer
+	binary.Write(&raw, binary.LittleEndian, uint32(2))
+	writeLPS(&raw, []byte(doc))
+	var bg [4]byte
+	binary.LittleEndian.PutUint32(bg[:], bgcolor)
+	writeLPS(&raw, bg[:])
+
+	var z bytes.Buffer
+	zw := zlib.NewWriter(&z)
+	if _, err := zw.Write(raw.Bytes()); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+
+	data := make([]byte, base64.StdEncoding.EncodedLen(z.Len()))
+	base64.StdEncoding.Encode(data, z.Bytes())
+	return data, nil
+}
+
+// Decode unpacks rtf message and extracts the plain text and the formatting from it.
+func Decode(data []byte) (doc Document, err error) {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 {
+		return doc, nil
+	}
+
+	z, err := base64.StdEncoding.DecodeString(string(data))
+	if err != nil {
+		return doc, fmt.Errorf("rtf: could not decode base64: %v", err)
+	}
+	zr, err := zlib.NewReader(bytes.NewReader(z))
+	if err != nil {
+		return doc, fmt.Errorf("rtf: could not decompress: %v", err)
+	}
+	raw, err := ioutil.ReadAll(io.LimitReader(zr, MaxSize+1))
+	if err != nil {
+		return doc, fmt.Errorf("rtf: could not decompress: %v", err)
+	}
+	if len(raw) > MaxSize {
+		return doc, ErrTooLarge
+	}
+
+	if len(raw) < 4 {
+		return doc, errors.New("rtf: message too short")
+	}
+	count := binary.LittleEndian.Uint32(raw)
+	raw = raw[4:]
+
+	doc.BgColor = DefaultBgColor
+	for i := uint32(0); i < count && len(raw) > 0; i++ {
+		var field []byte
+		field, raw, err = nextLPS(raw)
+		if err != nil {
+			return doc, fmt.Errorf("rtf: could not read field %d: %v", i, err)
+		}
+		switch i {
+		case 0:
+			doc.RTF = string(field)
+		case 1:
+			if len(field) >= 4 {
+				doc.BgColor = binary.LittleEndian.Uint32(field)
+			}
+		}
+	}
+
+	doc.Text, doc.Font, doc.Color = parse(doc.RTF)
+	return doc, nil
+}
+
+func writeLPS(buf *bytes.Buffer, v []byte) {
+	binary.Write(buf, binary.LittleEndian, uint32(len(v)))
+	buf.Write(v)
+}
+
+func nextLPS(v []byte) ([]byte, []byte, error) {
+	if len(v) < 4 {
+		return nil, v, errors.New("out of bound")
+	}
+	l := binary.LittleEndian.Uint32(v)
+	v = v[4:]
+	if int(l) > len(v) {
+		return nil, v, errors.New("out of bound")
+	}
+	return v[:l], v[l:], nil
+}
+
+// render builds rtf document from the plain text.
+func render(text, font string, color uint32) string {
+	var b strings.Builder
+	b.WriteString(`{\rtf1\ansi\ansicpg1251\deff0\deflang1049`)
+	fmt.Fprintf(&b, `{\fonttbl{\f0\fnil\fcharset204 %s;}}`, escape(font))
+	fmt.Fprintf(&b, `{\colortbl ;\red%d\green%d\blue%d;}`, color&0xff, color>>8&0xff, color>>16&0xff)
+	b.WriteString(`\viewkind4\uc1\pard\cf1\f0\fs20 `)
+	b.WriteString(escape(text))
+	b.WriteString(`\par}`)
+	return b.String()
+}
+
+// escape escapes rtf control characters in s, and encodes non-ascii characters as unicode control words.
+func escape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '\\' || r == '{' || r == '}':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r == '\n':
+			b.WriteString(`\par `)
+		case r == '\r':
+		case r == '\t':
+			b.WriteString(`\tab `)
+		case r < 0x80:
+			b.WriteRune(r)
+		case r < 0x10000:
+			fmt.Fprintf(&b, `\u%d?`, int16(r))
+		default:
+			// characters outside of BMP are written as a surrogate pair.
+			r -= 0x10000
+			fmt.Fprintf(&b, `\u%d?\u%d?`, int16(0xD800+(r>>10)), int16(0xDC00+(r&0x3ff)))
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,116 @@
+package rtf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/base64"
+	"testing"
+)
+
+func TestEncodeDecode(t *testing.T) {
+	tests := []struct {
+		text    string
+		font    string
+		color   uint32
+		bgcolor uint32
+	}{
+		{"hello", "Arial", 0x000000FF, DefaultBgColor},
+		{"привет, мир", DefaultFont, 0x00123456, 0x00654321},
+		{"{braces} and \\backslash", "Times New Roman", DefaultColor, DefaultBgColor},
+		{"line1\nline2\tcol", DefaultFont, DefaultColor, DefaultBgColor},
+		{"smile 🙂 ok", DefaultFont, DefaultColor, DefaultBgColor},
+	}
+	for _, tt := range tests {
+		data, err := Encode(tt.text, tt.font, tt.color, tt.bgcolor)
+		if err != nil {
+			t.Errorf("%q: %v", tt.text, err)
+			continue
+		}
+		doc, err := Decode(data)
+		if err != nil {
+			t.Errorf("%q: %v", tt.text, err)
+			continue
+		}
+		want := Format{Font: tt.font, Color: tt.color, BgColor: tt.bgcolor}
+		if doc.Text != tt.text || doc.Format != want {
+			t.Errorf("%q: got %q %+v, want %q %+v", tt.text, doc.Text, doc.Format, tt.text, want)
+		}
+	}
+}
+
+func TestDecodeTooLarge(t *testing.T) {
+	var z bytes.Buffer
+	zw := zlib.NewWriter(&z)
+	zw.Write(make([]byte, MaxSize+1))
+	zw.Close()
+	data := []byte(base64.StdEncoding.EncodeToString(z.Bytes()))
+
+	if _, err := Decode(data); err != ErrTooLarge {
+		t.Fatalf("got %v, want %v", err, ErrTooLarge)
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name  string
+		doc   string
+		text  string
+		font  string
+		color uint32
+	}{
+		{
+			name: "plain",
+			doc:  `{\rtf1\ansi hello\par world\par}`,
+			text: "hello\nworld",
+		},
+		{
+			name: "unicode",
+			doc:  `{\rtf1\uc1 \u1087?\u1088?\u1080?}`,
+			text: "при",
+		},
+		{
+			name: "surrogate pair",
+			doc:  `{\rtf1\uc1 a\u-10179?\u-8638?b}`,
+			text: "a🙂b",
+		},
+		{
+			name: "unicode with longer fallback",
+			doc:  `{\rtf1\uc2 \u1087??x}`,
+			text: "пx",
+		},
+		{
+			name: "cp1251",
+			doc:  `{\rtf1\ansi\ansicpg1251 \'cf\'f0\'e8\'e2\'e5\'f2}`,
+			text: "Привет",
+		},
+		{
+			name:  "font and color tables",
+			doc:   `{\rtf1{\fonttbl{\f0\fnil Tahoma;}{\f1\fnil\fcharset204 Arial;}}{\colortbl ;\red255\green0\blue0;\red0\green128\blue255;}\cf2\f1 text}`,
+			text:  "text",
+			font:  "Arial",
+			color: 0x00FF8000,
+		},
+		{
+			name: "default font",
+			doc:  `{\rtf1{\fonttbl{\f0\fnil Tahoma;}}text}`,
+			text: "text",
+			font: "Tahoma",
+		},
+		{
+			name: "skipped destinations",
+			doc:  `{\rtf1{\stylesheet{\s0 Normal;}}{\info{\author me}}{\*\generator Msftedit;}visible{\*\unknown hidden}}`,
+			text: "visible",
+		},
+		{
+			name: "escaped symbols",
+			doc:  `{\rtf1 \{a\}\\b\~c\_d}`,
+			text: "{a}\\b\u00a0c-d",
+		},
+	}
+	for _, tt := range tests {
+		text, font, color := parse(tt.doc)
+		if text != tt.text || font != tt.font || color != tt.color {
+			t.Errorf("%s: got %q %q 0x%06x, want %q %q 0x%06x", tt.name, text, font, color, tt.text, tt.font, tt.color)
+		}
+	}
+}
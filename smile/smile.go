@@ -0,0 +1,122 @@
+// Package smile translates smiles of Mail.Ru Agent into Unicode emoji and back.
+//
+// Official clients embed smiles into the message text as tags of two forms:
+//
+//	<###20###img010>                   - base smiles (FeatureBaseSmiles)
+//	<SMILE>id='S:10' alt=':-)'</SMILE> - advanced smiles (FeatureAdvancedSmiles)
+package smile
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Smile is an entry of the translation table.
+type Smile struct {
+	// ID is the number of the smile in base tags.
+	ID int
+	// Code is the id of the smile in advanced tags.
+	Code string
+	// Alt is a text representation of the smile.
+	Alt   string
+	Emoji string
+}
+
+// Table is the translation table of smiles.
+var Table = []Smile{
+	{0, "S:10", ":-)", "\U0001F642"},
+	{1, "S:11", ";-)", "\U0001F609"},
+	{2, "S:12", ":-D", "\U0001F600"},
+	{3, "S:13", ":-(", "\U0001F641"},
+	{4, "S:14", ":'(", "\U0001F622"},
+	{5, "S:15", ":-P", "\U0001F61B"},
+	{6, "S:16", ":-O", "\U0001F62E"},
+	{7, "S:17", "8-)", "\U0001F60E"},
+	{8, "S:18", ":-*", "\U0001F618"},
+	{9, "S:19", ":-[", "\U0001F633"},
+	{10, "S:20", ">:-(", "\U0001F620"},
+	{11, "S:21", ":-\\", "\U0001F615"},
+	{12, "S:22", ":-|", "\U0001F610"},
+	{13, "S:23", "O:-)", "\U0001F607"},
+	{14, "S:24", "]:->", "\U0001F608"},
+	{15, "S:25", ":-X", "\U0001F910"},
+	{16, "S:26", "*ROFL*", "\U0001F923"},
+	{17, "S:27", "*SLEEP*", "\U0001F634"},
+	{18, "S:28", "*HEART*", "❤️"},
+	{19, "S:29", "*FLOWER*", "\U0001F339"},
+	{20, "S:30", "*THUMBS UP*", "\U0001F44D"},
+	{21, "S:31", "*THUMBS DOWN*", "\U0001F44E"},
+	{22, "S:32", "*BEER*", "\U0001F37A"},
+	{23, "S:33", "*CAKE*", "\U0001F382"},
+}
+
+// variationSelector is appended to some emoji to request their colored presentation.
+const variationSelector = "\uFE0F"
+
+var (
+	// base and advanced tags number smiles differently, so each form has its own table.
+	byID    = make(map[int]Smile, len(Table))
+	byCode  = make(map[string]Smile, len(Table))
+	byAlt   = make(map[string]Smile, len(Table))
+	encoder *strings.Replacer
+	baseTag = regexp.MustCompile(`<###\d+###img(\d+)>`)
+	advTag  = regexp.MustCompile(`<SMILE>id='?([^'\s<>]*)'?(?: alt='([^']*)')?</SMILE>`)
+)
+
+func init() {
+	pairs := make([]string, 0, 4*len(Table))
+	for _, s := range Table {
+		byID[s.ID] = s
+		byCode[s.Code] = s
+		byAlt[s.Alt] = s
+		pairs = append(pairs, s.Emoji, Tag(s.ID))
+		// the emoji is often typed without the selector, the longer form is matched first.
+		if bare := strings.TrimSuffix(s.Emoji, variationSelector); bare != s.Emoji {
+			pairs = append(pairs, bare, Tag(s.ID))
+		}
+	}
+	encoder = strings.NewReplacer(pairs...)
+}
+
+// Tag returns the base tag of the smile with the given id.
+func Tag(id int) string {
+	return fmt.Sprintf("<###20###img%03d>", id)
+}
+
+// Decode replaces smile tags in text with emoji.
+// Advanced tags are translated by their alt text, and by their id only if they have no alt text.
+// Tags of unknown smiles are replaced with their alt text, if they have one, and are kept as is otherwise.
+func Decode(text string) string {
+	text = baseTag.ReplaceAllStringFunc(text, func(tag string) string {
+		m := baseTag.FindStringSubmatch(tag)
+		id, err := strconv.Atoi(m[1])
+		if err != nil {
+			return tag
+		}
+		if s, ok := byID[id]; ok {
+			return s.Emoji
+		}
+		return tag
+	})
+	return advTag.ReplaceAllStringFunc(text, func(tag string) string {
+		m := advTag.FindStringSubmatch(tag)
+		code, alt := m[1], m[2]
+		if alt != "" {
+			if s, ok := byAlt[alt]; ok {
+				return s.Emoji
+			}
+			return alt
+		}
+		if s, ok := byCode[code]; ok {
+			return s.Emoji
+		}
+		return tag
+	})
+}
+
+// Encode replaces emoji in text with base smile tags.
+func Encode(text string) string {
+	return encoder.Replace(text)
+}
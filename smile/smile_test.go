@@ -0,0 +1,55 @@
+package smile
+
+import "testing"
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{"base", "hi <###20###img000>", "hi \U0001F642"},
+		{"base angry", "<###20###img010>", "\U0001F620"},
+		{"base unknown", "<###20###img099>", "<###20###img099>"},
+		{"advanced", "<SMILE>id='S:10' alt=':-)'</SMILE>", "\U0001F642"},
+		{"advanced without alt", "<SMILE>id='S:20'</SMILE>", "\U0001F620"},
+		{"advanced alt disagrees with id", "<SMILE>id='S:20' alt=':-)'</SMILE>", "\U0001F642"},
+		{"advanced unknown id", "<SMILE>id='S:99' alt=':-D'</SMILE>", "\U0001F600"},
+		{"advanced unknown alt", "<SMILE>id='S:10' alt='*DANCE*'</SMILE>", "*DANCE*"},
+		{"advanced unknown", "<SMILE>id='S:99'</SMILE>", "<SMILE>id='S:99'</SMILE>"},
+		{"mixed", "a<###20###img001>b<SMILE>id='S:12' alt=':-D'</SMILE>c", "a\U0001F609b\U0001F600c"},
+		{"no tags", "plain text", "plain text"},
+	}
+	for _, tt := range tests {
+		if got := Decode(tt.text); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestEncode(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{"smile", "hi \U0001F642", "hi <###20###img000>"},
+		{"heart", "I ❤️ you", "I <###20###img018> you"},
+		{"heart without selector", "I ❤ you", "I <###20###img018> you"},
+		{"unknown emoji", "\U0001F680", "\U0001F680"},
+		{"no emoji", "plain text", "plain text"},
+	}
+	for _, tt := range tests {
+		if got := Encode(tt.text); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestEncodeDecode(t *testing.T) {
+	for _, s := range Table {
+		if got := Decode(Encode(s.Emoji)); got != s.Emoji {
+			t.Errorf("%s: got %q, want %q", s.Alt, got, s.Emoji)
+		}
+	}
+}
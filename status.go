@@ -0,0 +1,70 @@
+package mrim
+
+import (
+	"context"
+)
+
+// Status is the user's presence, optionally extended with xstatus.
+type Status struct {
+	// Code is one of Status* constants, e.g. StatusOnline.
+	Code      uint32
+	Invisible bool
+	// URI identifies the extended status, e.g. "status_chat", "status_dnd" or custom "status_N".
+	URI   string
+	Title string
+	Desc  string
+}
+
+// code returns the status code as it's sent to the server.
+func (s Status) code() uint32 {
+	code := s.Code
+	if s.URI != "" && code != StatusOffline {
+		code = StatusUserDefined
+	}
+	if s.Invisible {
+		code |= StatusFlagInvisible
+	}
+	return code
+}
+
+// csChangeStatus is the body of "MRIM_CS_CHANGE_STATUS".
+type csChangeStatus struct {
+	Status   uint32 `mrim:"ul"`
+	URI      string `mrim:"lps,cp1251"`
+	Title    string `mrim:"lpsw"`
+	Desc     string `mrim:"lpsw"`
+	Features uint32 `mrim:"ul"`
+}
+
+// SetStatus sends "MRIM_CS_CHANGE_STATUS", which changes the user's presence.
+func (c *Client) SetStatus(ctx context.Context, status Status) error {
+	data, err := Marshal(csChangeStatus{
+		Status:   status.code(),
+		URI:      status.URI,
+		Title:    status.Title,
+		Desc:     status.Desc,
+		Features: c.features,
+	})
+	if err != nil {
+		return err
+	}
+	err = c.currentConn().Do(ctx, MsgCSChangeStatus, data)
+	if err != nil {
+		return err
+	}
+	c.setStatus(status)
+	return nil
+}
+
+func (c *Client) setStatus(status Status) {
+	c.mu.Lock()
+	c.status = status
+	c.mu.Unlock()
+}
+
+// Status returns the last status set by the user.
+func (c *Client) Status() Status {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.status
+}
@@ -0,0 +1,41 @@
+package mrim
+
+import (
+	"context"
+	"time"
+)
+
+// TypingInterval is the minimum interval between typing notifications sent to the same contact.
+const TypingInterval = 5 * time.Second
+
+// TypingEvent is a notification, that the contact is typing a message.
+// It's received as an empty message with MessageFlagNotify.
+type TypingEvent struct {
+	From string
+}
+
+// SendTyping notifies the contact, that the user is typing a message.
+// Notifications are throttled, so it's safe to call SendTyping on every key press:
+// calls made within TypingInterval since the last notification to the same contact do nothing.
+func (c *Client) SendTyping(ctx context.Context, to string) error {
+	now := time.Now()
+
+	c.mu.Lock()
+	if last, ok := c.typing[to]; ok && now.Sub(last) < TypingInterval {
+		c.mu.Unlock()
+		return nil
+	}
+	c.typing[to] = now
+	c.mu.Unlock()
+
+	opt := &MessageOptions{
+		Flags: MessageFlagNotify | MessageFlagNorecv,
+	}
+	err := c.SendMessage(ctx, to, "", opt)
+	if err != nil {
+		c.mu.Lock()
+		delete(c.typing, to)
+		c.mu.Unlock()
+	}
+	return err
+}